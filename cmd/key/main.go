@@ -1,45 +1,132 @@
 package main
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"crypto"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/keys"
 )
 
+const usage = `Usage:
+  key generate [-alg rsa|ecdsa|ed25519] [-bits n] [-kid id] [-private path] [-public path]
+  key inspect <key.pem>...
+  key fingerprint <key.pem>...
+
+Without a command, an RSA key pair is generated into ./private.pem and ./public.pem.
+`
+
 func main() {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	log.SetFlags(0)
+
+	args := os.Args[1:]
+	command := "generate"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "generate":
+		err = generate(args)
+	case "inspect":
+		err = inspect(args, false)
+	case "fingerprint":
+		err = inspect(args, true)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
 	if err != nil {
 		log.Fatal(err)
 	}
+}
 
-	publicKey := &privateKey.PublicKey
-	privateKeyBytes := x509.MarshalPKCS1PrivateKey(privateKey)
-
-	privateKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "SERVER PRIVATE KEY",
-		Bytes: privateKeyBytes,
-	})
+func generate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	alg := fs.String("alg", keys.RSA, "Key algorithm: rsa, ecdsa or ed25519")
+	bits := fs.Int("bits", 0, "RSA key size or ECDSA curve size (default 2048 for RSA, 256 for ECDSA)")
+	kid := fs.String("kid", "", "Key ID written to the PEM headers (default derived from the public key)")
+	privatePath := fs.String("private", "./private.pem", "Private key output path")
+	publicPath := fs.String("public", "./public.pem", "Public key output path")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 
-	err = os.WriteFile("./private.pem", privateKeyPEM, 0644)
+	key, err := keys.Generate(*alg, *bits)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	publicKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if *kid == "" {
+		if *kid, err = keys.KeyID(key.Public()); err != nil {
+			return err
+		}
+	}
+
+	privatePEM, err := keys.EncodePrivateKey(key, *kid)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	publicPEM, err := keys.EncodePublicKey(key.Public(), *kid)
+	if err != nil {
+		return err
 	}
 
-	publicKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "AGENT PUBLIC KEY",
-		Bytes: publicKeyBytes,
-	})
+	if err = os.WriteFile(*privatePath, privatePEM, 0600); err != nil {
+		return err
+	}
+	if err = os.WriteFile(*publicPath, publicPEM, 0644); err != nil {
+		return err
+	}
 
-	err = os.WriteFile("./public.pem", publicKeyPEM, 0644)
+	fingerprint, err := keys.Fingerprint(key.Public())
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	fmt.Printf("%s key %s written to %s and %s\n%s\n", keys.Describe(key.Public()), *kid, *privatePath, *publicPath, fingerprint)
+
+	return nil
+}
+
+func inspect(paths []string, fingerprintOnly bool) error {
+	if len(paths) == 0 {
+		return errors.New("no key files given")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		kind := "private"
+		var public crypto.PublicKey
+		var kid string
+		if signer, id, err := keys.ParsePrivateKey(data); err == nil {
+			public, kid = signer.Public(), id
+		} else if public, kid, err = keys.ParsePublicKey(data); err == nil {
+			kind = "public"
+		} else {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		fingerprint, err := keys.Fingerprint(public)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if fingerprintOnly {
+			fmt.Printf("%s  %s\n", fingerprint, path)
+			continue
+		}
+		fmt.Printf("%s:\n  type: %s %s key\n  key id: %s\n  fingerprint: %s\n", path, keys.Describe(public), kind, kid, fingerprint)
+	}
+
+	return nil
 }
@@ -6,37 +6,67 @@ import (
 	"time"
 
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
-	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	certReloadInterval = time.Minute
+	shutdownTimeout    = 10 * time.Second
+)
+
 func StartClient(ctx context.Context, c *config.AgentConfig) {
 	logrus.Info("Agent is running...")
 	wg := &sync.WaitGroup{}
 
-	metric := storage.NewMetrics()
+	metric := NewBuffer()
 
-	pollerInterval := time.Duration(c.PollInterval) * time.Second
-	pollerTicker := time.NewTicker(pollerInterval)
-	defer pollerTicker.Stop()
+	collectors, err := newCollectors(c)
+	if err != nil {
+		logrus.Errorf("Error create collectors: %v", err)
+		return
+	}
+
+	transport, err := NewTransport(c)
+	if err != nil {
+		logrus.Errorf("Error create transport: %v", err)
+		return
+	}
+	if c.SpoolDir != "" {
+		spool, err := NewSpool(transport, c.SpoolDir, c.SpoolMaxSize, time.Duration(c.SpoolMaxAge)*time.Second)
+		if err != nil {
+			logrus.Errorf("Error open spool: %v", err)
+			if err := transport.Close(); err != nil {
+				logrus.Errorf("Error close transport: %v", err)
+			}
+			return
+		}
+		transport = spool
+	}
+	defer func() {
+		if err := transport.Close(); err != nil {
+			logrus.Errorf("Error close transport: %v", err)
+		}
+	}()
 
 	reportInterval := time.Duration(c.ReportInterval) * time.Second
 	reportTicker := time.NewTicker(reportInterval)
 	defer reportTicker.Stop()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		runUpdateMemStatMetrics(ctx, pollerTicker, metric)
-	}()
-
-	for i := 1; i < c.RateLimit; i++ {
+	if c.Certs != nil {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			RunSendMetric(ctx, reportTicker, c, metric)
+			c.Certs.Run(ctx, certReloadInterval)
 		}()
 	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runCollectors(ctx, collectors, metric)
+	}()
+
+	RunReporter(ctx, reportTicker.C, transport, metric, c.RateLimit, shutdownTimeout)
+
 	wg.Wait()
 }
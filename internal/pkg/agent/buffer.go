@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+)
+
+// Buffer keeps the metrics polled since the last send: the latest value of every gauge
+// and the sum of the increments of every counter. Taking a snapshot hands the counter
+// increments over to the sender, so concurrent senders never send the same increment
+// twice and increments polled meanwhile go to the next snapshot.
+type Buffer struct {
+	lock     sync.Mutex
+	gauges   map[string]metrics.Gauge
+	counters map[string]metrics.Counter
+}
+
+func NewBuffer() *Buffer {
+	return &Buffer{
+		gauges:   make(map[string]metrics.Gauge),
+		counters: make(map[string]metrics.Counter),
+	}
+}
+
+func (b *Buffer) UpdateGaugeMetric(_ context.Context, name string, value metrics.Gauge) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.gauges[name] = value
+	return nil
+}
+
+func (b *Buffer) UpdateCounterMetric(_ context.Context, name string, value metrics.Counter) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.counters[name] += value
+	return nil
+}
+
+// Snapshot returns the gauges and takes the counter increments out of the buffer.
+func (b *Buffer) Snapshot() []*metrics.Metrics {
+	b.lock.Lock()
+	gauges := make(map[string]metrics.Gauge, len(b.gauges))
+	for name, value := range b.gauges {
+		gauges[name] = value
+	}
+	counters := b.counters
+	b.counters = make(map[string]metrics.Counter)
+	b.lock.Unlock()
+
+	batch := make([]*metrics.Metrics, 0, len(gauges)+len(counters))
+	for name, value := range gauges {
+		value := value
+		batch = append(batch, &metrics.Metrics{ID: name, MType: metrics.GaugeMetricName, Value: &value})
+	}
+	for name, delta := range counters {
+		delta := delta
+		batch = append(batch, &metrics.Metrics{ID: name, MType: metrics.CounterMetricName, Delta: &delta})
+	}
+	sort.Slice(batch, func(i, j int) bool { return batch[i].ID < batch[j].ID })
+
+	return batch
+}
+
+// Restore gives back the counter increments of a snapshot that could not be sent.
+// Gauges are still in the buffer, possibly with newer values.
+func (b *Buffer) Restore(batch []*metrics.Metrics) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, m := range batch {
+		if m.MType == metrics.CounterMetricName && m.Delta != nil {
+			b.counters[m.ID] += *m.Delta
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package agent_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferSnapshot(t *testing.T) {
+	ctx := context.Background()
+	b := agent.NewBuffer()
+	require.NoError(t, b.UpdateGaugeMetric(ctx, "Alloc", 1.5))
+	require.NoError(t, b.UpdateCounterMetric(ctx, agent.PollCount, 1))
+	require.NoError(t, b.UpdateCounterMetric(ctx, agent.PollCount, 1))
+
+	first := b.Snapshot()
+	count, alloc := totals([][]*metrics.Metrics{first})
+	assert.Equal(t, metrics.Counter(2), count)
+	assert.Equal(t, metrics.Gauge(1.5), alloc)
+
+	// Counters start over, gauges keep their last value.
+	second := b.Snapshot()
+	require.Len(t, second, 1)
+	assert.Equal(t, "Alloc", second[0].ID)
+
+	require.NoError(t, b.UpdateGaugeMetric(ctx, "Alloc", 2.5))
+	require.NoError(t, b.UpdateCounterMetric(ctx, agent.PollCount, 1))
+	b.Restore(first)
+	count, alloc = totals([][]*metrics.Metrics{b.Snapshot()})
+	assert.Equal(t, metrics.Counter(3), count)
+	assert.Equal(t, metrics.Gauge(2.5), alloc)
+}
+
+func TestBufferConcurrentSenders(t *testing.T) {
+	const (
+		polls   = 1000
+		senders = 4
+	)
+	ctx := context.Background()
+	b := agent.NewBuffer()
+
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		sent metrics.Counter
+	)
+	done := make(chan struct{})
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				batch := b.Snapshot()
+				// Every other send of odd senders fails.
+				if i%2 == 1 && n%2 == 0 {
+					b.Restore(batch)
+					continue
+				}
+				for _, m := range batch {
+					if m.Delta != nil {
+						lock.Lock()
+						sent += *m.Delta
+						lock.Unlock()
+					}
+				}
+			}
+		}(i)
+	}
+
+	for i := 0; i < polls; i++ {
+		require.NoError(t, b.UpdateCounterMetric(ctx, agent.PollCount, 1))
+	}
+	close(done)
+	wg.Wait()
+
+	for _, m := range b.Snapshot() {
+		if m.Delta != nil {
+			sent += *m.Delta
+		}
+	}
+	assert.Equal(t, metrics.Counter(polls), sent)
+}
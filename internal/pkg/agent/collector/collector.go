@@ -0,0 +1,107 @@
+// Package collector holds the sources of agent metrics. Every collector registers
+// itself under a name, the agent configuration picks which of them run and how often.
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+)
+
+// Recorder takes the collected metrics. Counter values are increments.
+type Recorder interface {
+	UpdateGaugeMetric(ctx context.Context, name string, value metrics.Gauge) error
+	UpdateCounterMetric(ctx context.Context, name string, value metrics.Counter) error
+}
+
+// Collector reads one group of metrics on every call of Collect. Collectors run in
+// their own goroutines, a collector is never called concurrently with itself.
+type Collector interface {
+	Collect(ctx context.Context, r Recorder) error
+}
+
+// Factory builds a collector from the agent configuration.
+type Factory func(c *config.AgentConfig) (Collector, error)
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Factory)
+)
+
+// Register makes a collector available by name. It panics when the name is taken.
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("collector: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// New builds the collector registered as name.
+func New(name string, c *config.AgentConfig) (Collector, error) {
+	registryLock.RLock()
+	factory, ok := registry[name]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown collector %q, available: %v", name, Names())
+	}
+
+	collector, err := factory(c)
+	if err != nil {
+		return nil, fmt.Errorf("create collector %s: %w", name, err)
+	}
+
+	return collector, nil
+}
+
+// Names lists the registered collectors.
+func Names() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// CollectorFunc adapts a function to a Collector.
+type CollectorFunc func(ctx context.Context, r Recorder) error
+
+func (f CollectorFunc) Collect(ctx context.Context, r Recorder) error {
+	return f(ctx, r)
+}
+
+// gauges records a group of gauges and reports all the failures.
+func gauges(ctx context.Context, r Recorder, values map[string]metrics.Gauge) error {
+	var errs []error
+	for name, value := range values {
+		if err := r.UpdateGaugeMetric(ctx, name, value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// counters records a group of counter increments and reports all the failures.
+func counters(ctx context.Context, r Recorder, values map[string]metrics.Counter) error {
+	var errs []error
+	for name, value := range values {
+		if err := r.UpdateCounterMetric(ctx, name, value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,175 @@
+package collector_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/collector"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	assert.Subset(t, collector.Names(), []string{"cpu", "memory", "runtime"})
+
+	_, err := collector.New("unknown", &config.AgentConfig{})
+	assert.Error(t, err)
+
+	assert.Panics(t, func() {
+		collector.Register("runtime", func(*config.AgentConfig) (collector.Collector, error) {
+			return nil, nil
+		})
+	})
+}
+
+func TestRuntimeCollector(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMetrics()
+
+	runtime, err := collector.New("runtime", &config.AgentConfig{})
+	require.NoError(t, err)
+	require.NoError(t, runtime.Collect(ctx, store))
+	require.NoError(t, runtime.Collect(ctx, store))
+
+	count, ok := store.GetMetric(ctx, collector.PollCount, metrics.CounterMetricName)
+	require.True(t, ok)
+	assert.Equal(t, metrics.Counter(2), *count.Delta)
+
+	alloc, ok := store.GetMetric(ctx, "Alloc", metrics.GaugeMetricName)
+	require.True(t, ok)
+	assert.Positive(t, *alloc.Value)
+}
+
+func TestDiskCollector(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMetrics()
+
+	disk, err := collector.New("disk", &config.AgentConfig{})
+	require.NoError(t, err)
+	require.NoError(t, disk.Collect(ctx, store))
+	require.NoError(t, disk.Collect(ctx, store))
+
+	all, err := store.GetMetrics(ctx)
+	require.NoError(t, err)
+	for name, m := range all {
+		assert.NotContains(t, name, "/")
+		if m.MType == metrics.GaugeMetricName && strings.HasPrefix(m.ID, "DiskUsedPercent_") {
+			assert.GreaterOrEqual(t, float64(*m.Value), 0.0)
+			assert.LessOrEqual(t, float64(*m.Value), 100.0)
+		}
+	}
+}
+
+func TestNetCollector(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMetrics()
+
+	netCollector, err := collector.New("net", &config.AgentConfig{NetInterfaces: "lo"})
+	require.NoError(t, err)
+	require.NoError(t, netCollector.Collect(ctx, store))
+
+	_, ok := store.GetMetric(ctx, "NetBytesRecv_lo", metrics.CounterMetricName)
+	assert.False(t, ok, "the first poll only sets the base")
+	listen, ok := store.GetMetric(ctx, "TCPConnections_LISTEN", metrics.GaugeMetricName)
+	require.True(t, ok)
+	assert.GreaterOrEqual(t, float64(*listen.Value), 0.0)
+
+	require.NoError(t, netCollector.Collect(ctx, store))
+
+	all, err := store.GetMetrics(ctx)
+	require.NoError(t, err)
+	for _, m := range all {
+		if strings.HasPrefix(m.ID, "Net") {
+			assert.True(t, strings.HasSuffix(m.ID, "_lo"), m.ID)
+		}
+	}
+	_, ok = store.GetMetric(ctx, "NetBytesRecv_lo", metrics.CounterMetricName)
+	assert.True(t, ok)
+
+	_, err = collector.New("net", &config.AgentConfig{NetInterfacesExclude: "["})
+	assert.Error(t, err)
+}
+
+func TestCPUCollector(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMetrics()
+
+	cpuCollector, err := collector.New("cpu", &config.AgentConfig{})
+	require.NoError(t, err)
+	require.NoError(t, cpuCollector.Collect(ctx, store))
+
+	_, ok := store.GetMetric(ctx, "LoadAverage1", metrics.GaugeMetricName)
+	assert.True(t, ok)
+	_, ok = store.GetMetric(ctx, "CPUutilization1", metrics.GaugeMetricName)
+	assert.False(t, ok, "utilization needs two samples")
+
+	// Spin so that the second sample is some time after the first.
+	for deadline := time.Now().Add(50 * time.Millisecond); time.Now().Before(deadline); {
+	}
+	require.NoError(t, cpuCollector.Collect(ctx, store))
+
+	for core := 1; core <= runtime.NumCPU(); core++ {
+		name := fmt.Sprintf("CPUutilization%d", core)
+		m, ok := store.GetMetric(ctx, name, metrics.GaugeMetricName)
+		if !ok {
+			// A core idle and untouched for the whole interval has no new ticks.
+			continue
+		}
+		assert.GreaterOrEqual(t, float64(*m.Value), 0.0, name)
+		assert.LessOrEqual(t, float64(*m.Value), 100.0, name)
+	}
+}
+
+func TestProcessCollector(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMetrics()
+
+	pidfile := filepath.Join(t.TempDir(), "agent.pid")
+	writePid := func(pid int) {
+		require.NoError(t, os.WriteFile(pidfile, []byte(strconv.Itoa(pid)+"\n"), 0o600))
+	}
+	writePid(os.Getpid())
+
+	processes, err := collector.New("process", &config.AgentConfig{
+		Processes: "self=pidfile:" + pidfile + ";test=cmdline:" + regexp.QuoteMeta(os.Args[0]) +
+			";missing=pidfile:" + pidfile + ".none",
+	})
+	require.NoError(t, err)
+	require.NoError(t, processes.Collect(ctx, store))
+
+	gauge := func(name string) metrics.Gauge {
+		m, ok := store.GetMetric(ctx, name, metrics.GaugeMetricName)
+		require.True(t, ok, name)
+		return *m.Value
+	}
+	counter := func(name string) metrics.Counter {
+		m, ok := store.GetMetric(ctx, name, metrics.CounterMetricName)
+		require.True(t, ok, name)
+		return *m.Delta
+	}
+
+	assert.Equal(t, metrics.Gauge(1), gauge("ProcessCount_self"))
+	assert.Positive(t, gauge("ProcessRSS_self"))
+	assert.Positive(t, gauge("ProcessThreads_self"))
+	assert.Positive(t, gauge("ProcessFDs_self"))
+	assert.GreaterOrEqual(t, gauge("ProcessCount_test"), metrics.Gauge(1))
+	assert.Equal(t, metrics.Gauge(0), gauge("ProcessCount_missing"))
+
+	// A new pid in the pidfile is a restart.
+	writePid(os.Getppid())
+	require.NoError(t, processes.Collect(ctx, store))
+	assert.Equal(t, metrics.Counter(1), counter("ProcessRestarts_self"))
+	assert.Equal(t, metrics.Counter(0), counter("ProcessRestarts_test"))
+	assert.GreaterOrEqual(t, gauge("ProcessCPU_test"), metrics.Gauge(0))
+}
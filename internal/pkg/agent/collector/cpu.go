@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/load"
+)
+
+func init() {
+	Register("cpu", func(*config.AgentConfig) (Collector, error) {
+		return &cpuCollector{}, nil
+	})
+}
+
+// cpuCollector reports the utilization of every core, CPUutilization1 being the first,
+// with its user, system and iowait shares, and the load averages. Utilization is taken
+// over the time between two polls, so the first poll only reports the load.
+type cpuCollector struct {
+	last map[string]cpu.TimesStat
+}
+
+func (c *cpuCollector) Collect(ctx context.Context, r Recorder) error {
+	var errs []error
+	values := make(map[string]metrics.Gauge)
+
+	times, err := cpu.TimesWithContext(ctx, true)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error with cpu %w", err))
+	} else {
+		last := c.last
+		c.last = make(map[string]cpu.TimesStat, len(times))
+		for i, t := range times {
+			c.last[t.CPU] = t
+
+			prev, ok := last[t.CPU]
+			if !ok {
+				continue
+			}
+			usage, ok := cpuUsage(prev, t)
+			if !ok {
+				continue
+			}
+			core := strconv.Itoa(i + 1)
+			values["CPUutilization"+core] = metrics.Gauge(usage.busy)
+			values["CPUuser"+core] = metrics.Gauge(usage.user)
+			values["CPUsystem"+core] = metrics.Gauge(usage.system)
+			values["CPUiowait"+core] = metrics.Gauge(usage.iowait)
+		}
+	}
+
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error with load average %w", err))
+	} else {
+		values["LoadAverage1"] = metrics.Gauge(avg.Load1)
+		values["LoadAverage5"] = metrics.Gauge(avg.Load5)
+		values["LoadAverage15"] = metrics.Gauge(avg.Load15)
+	}
+
+	errs = append(errs, gauges(ctx, r, values))
+
+	return errors.Join(errs...)
+}
+
+// cpuShares are percentages of the time between two samples.
+type cpuShares struct {
+	busy   float64
+	user   float64
+	system float64
+	iowait float64
+}
+
+// cpuUsage compares two samples of a core. It fails when no time passed or the
+// counters went backwards.
+func cpuUsage(prev, cur cpu.TimesStat) (cpuShares, bool) {
+	total := cpuTotal(cur) - cpuTotal(prev)
+	if total <= 0 {
+		return cpuShares{}, false
+	}
+
+	idle := (cur.Idle + cur.Iowait) - (prev.Idle + prev.Iowait)
+	share := func(d float64) float64 {
+		return min(max(d/total*100, 0), 100)
+	}
+
+	return cpuShares{
+		busy:   share(total - idle),
+		user:   share((cur.User + cur.Nice) - (prev.User + prev.Nice)),
+		system: share((cur.System + cur.Irq + cur.Softirq) - (prev.System + prev.Irq + prev.Softirq)),
+		iowait: share(cur.Iowait - prev.Iowait),
+	}, true
+}
+
+func cpuTotal(t cpu.TimesStat) float64 {
+	total := t.Total()
+	if runtime.GOOS == "linux" {
+		// Guest time is already counted in user time.
+		total -= t.Guest + t.GuestNice
+	}
+
+	return total
+}
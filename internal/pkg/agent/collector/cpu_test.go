@@ -0,0 +1,25 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPUUsage(t *testing.T) {
+	prev := cpu.TimesStat{CPU: "cpu0", User: 100, System: 50, Idle: 800, Iowait: 50}
+	cur := cpu.TimesStat{CPU: "cpu0", User: 130, Nice: 10, System: 60, Irq: 10, Idle: 920, Iowait: 70}
+
+	usage, ok := cpuUsage(prev, cur)
+	assert.True(t, ok)
+	assert.InDelta(t, 30.0, usage.busy, 1e-9)
+	assert.InDelta(t, 20.0, usage.user, 1e-9)
+	assert.InDelta(t, 10.0, usage.system, 1e-9)
+	assert.InDelta(t, 10.0, usage.iowait, 1e-9)
+
+	_, ok = cpuUsage(cur, cur)
+	assert.False(t, ok)
+	_, ok = cpuUsage(cur, prev)
+	assert.False(t, ok)
+}
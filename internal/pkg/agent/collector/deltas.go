@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"strings"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+)
+
+// counterDeltas turns the cumulative counters of the system into the increments since
+// the previous poll, which is what a counter metric carries. A counter seen for the
+// first time or gone backwards, after a reboot or a device reset, only sets the base.
+type counterDeltas struct {
+	last map[string]uint64
+}
+
+func (d *counterDeltas) next(values map[string]uint64) map[string]metrics.Counter {
+	deltas := make(map[string]metrics.Counter, len(values))
+	for name, value := range values {
+		if last, ok := d.last[name]; ok && value >= last {
+			deltas[name] = metrics.Counter(value - last)
+		}
+	}
+	d.last = values
+
+	return deltas
+}
+
+// metricName appends a label such as a mountpoint or a device to a metric name. Slashes
+// become underscores so that the name fits in a URL path, "/" itself is "root".
+func metricName(name, label string) string {
+	label = strings.Trim(label, "/")
+	if label == "" {
+		label = "root"
+	}
+
+	return name + "_" + strings.ReplaceAll(label, "/", "_")
+}
+
+// splitList reads a comma separated setting.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,24 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterDeltas(t *testing.T) {
+	var d counterDeltas
+
+	assert.Empty(t, d.next(map[string]uint64{"a": 10, "b": 5}))
+	assert.Equal(t, map[string]metrics.Counter{"a": 5, "b": 0}, d.next(map[string]uint64{"a": 15, "b": 5}))
+	// b went backwards and c is new, both only set the base.
+	assert.Equal(t, map[string]metrics.Counter{"a": 1}, d.next(map[string]uint64{"a": 16, "b": 1, "c": 7}))
+	assert.Equal(t, map[string]metrics.Counter{"b": 2, "c": 0}, d.next(map[string]uint64{"b": 3, "c": 7}))
+}
+
+func TestMetricName(t *testing.T) {
+	assert.Equal(t, "DiskUsed_root", metricName("DiskUsed", "/"))
+	assert.Equal(t, "DiskUsed_var_lib", metricName("DiskUsed", "/var/lib/"))
+	assert.Equal(t, "DiskReadBytes_sda", metricName("DiskReadBytes", "sda"))
+}
@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func init() {
+	Register("disk", func(c *config.AgentConfig) (Collector, error) {
+		return newDiskCollector(c.DiskMounts, c.DiskMountsExclude)
+	})
+}
+
+// diskCollector reports the space and inodes of the mounted filesystems passing the
+// filter and the I/O of the devices behind them.
+type diskCollector struct {
+	mounts nameFilter
+	io     counterDeltas
+}
+
+func newDiskCollector(include, exclude string) (*diskCollector, error) {
+	mounts, err := newNameFilter(include, exclude)
+	if err != nil {
+		return nil, fmt.Errorf("mountpoints: %w", err)
+	}
+
+	return &diskCollector{mounts: mounts}, nil
+}
+
+func (c *diskCollector) Collect(ctx context.Context, r Recorder) error {
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return fmt.Errorf("error with partitions %w", err)
+	}
+
+	var (
+		errs    []error
+		devices []string
+		seen    = make(map[string]bool)
+		values  = make(map[string]metrics.Gauge)
+	)
+	for _, p := range partitions {
+		if !c.mounts.match(p.Mountpoint) {
+			continue
+		}
+
+		// A mount whose usage fails still has its device polled, the I/O deltas would
+		// start over otherwise.
+		if device := filepath.Base(p.Device); !seen[device] {
+			seen[device] = true
+			devices = append(devices, device)
+		}
+
+		usage, err := disk.UsageWithContext(ctx, p.Mountpoint)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error with usage of %s %w", p.Mountpoint, err))
+			continue
+		}
+		values[metricName("DiskTotal", p.Mountpoint)] = metrics.Gauge(usage.Total)
+		values[metricName("DiskUsed", p.Mountpoint)] = metrics.Gauge(usage.Used)
+		values[metricName("DiskFree", p.Mountpoint)] = metrics.Gauge(usage.Free)
+		values[metricName("DiskUsedPercent", p.Mountpoint)] = metrics.Gauge(usage.UsedPercent)
+		values[metricName("DiskInodesUsed", p.Mountpoint)] = metrics.Gauge(usage.InodesUsed)
+		values[metricName("DiskInodesFree", p.Mountpoint)] = metrics.Gauge(usage.InodesFree)
+	}
+
+	if len(devices) > 0 {
+		ioValues, err := c.collectIO(ctx, devices, values)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		errs = append(errs, counters(ctx, r, ioValues))
+	}
+	errs = append(errs, gauges(ctx, r, values))
+
+	return errors.Join(errs...)
+}
+
+// collectIO adds the operations in progress to values and returns the I/O since the
+// previous poll.
+func (c *diskCollector) collectIO(
+	ctx context.Context,
+	devices []string,
+	values map[string]metrics.Gauge,
+) (map[string]metrics.Counter, error) {
+	stats, err := disk.IOCountersWithContext(ctx, devices...)
+	if err != nil {
+		return nil, fmt.Errorf("error with io counters %w", err)
+	}
+
+	totals := make(map[string]uint64, len(stats)*4)
+	for device, stat := range stats {
+		values[metricName("DiskIOInProgress", device)] = metrics.Gauge(stat.IopsInProgress)
+		totals[metricName("DiskReadBytes", device)] = stat.ReadBytes
+		totals[metricName("DiskWriteBytes", device)] = stat.WriteBytes
+		totals[metricName("DiskReadOps", device)] = stat.ReadCount
+		totals[metricName("DiskWriteOps", device)] = stat.WriteCount
+	}
+
+	return c.io.next(totals), nil
+}
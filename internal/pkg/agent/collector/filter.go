@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// nameFilter selects mountpoints, interfaces and the like by shell patterns. A name
+// passes when it matches one of the include patterns, or there are none, and none of
+// the exclude patterns.
+type nameFilter struct {
+	include []string
+	exclude []string
+}
+
+func newNameFilter(include, exclude string) (nameFilter, error) {
+	f := nameFilter{
+		include: splitList(include),
+		exclude: splitList(exclude),
+	}
+	for _, pattern := range append(append([]string{}, f.include...), f.exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return f, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	return f, nil
+}
+
+func (f nameFilter) match(name string) bool {
+	if len(f.include) > 0 && !matchAny(f.include, name) {
+		return false
+	}
+
+	return !matchAny(f.exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,26 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameFilter(t *testing.T) {
+	c, err := newNameFilter("/, /var/*", "/var/tmp")
+	require.NoError(t, err)
+
+	assert.True(t, c.match("/"))
+	assert.True(t, c.match("/var/lib"))
+	assert.False(t, c.match("/var/tmp"))
+	assert.False(t, c.match("/home"))
+
+	all, err := newNameFilter("", "/snap/*")
+	require.NoError(t, err)
+	assert.True(t, all.match("/home"))
+	assert.False(t, all.match("/snap/core"))
+
+	_, err = newNameFilter("[", "")
+	assert.Error(t, err)
+}
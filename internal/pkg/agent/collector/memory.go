@@ -0,0 +1,28 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+func init() {
+	Register("memory", func(*config.AgentConfig) (Collector, error) {
+		return CollectorFunc(collectMemory), nil
+	})
+}
+
+func collectMemory(ctx context.Context, r Recorder) error {
+	vm, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error with virtualMemory %w", err)
+	}
+
+	return gauges(ctx, r, map[string]metrics.Gauge{
+		"TotalMemory": metrics.Gauge(vm.Total),
+		"FreeMemory":  metrics.Gauge(vm.Free),
+	})
+}
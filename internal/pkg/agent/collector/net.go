@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// tcpStates are reported on every poll, so a state with no connections left drops to zero.
+var tcpStates = []string{
+	"ESTABLISHED", "SYN_SENT", "SYN_RECV", "FIN_WAIT1", "FIN_WAIT2", "TIME_WAIT",
+	"CLOSE", "CLOSE_WAIT", "LAST_ACK", "LISTEN", "CLOSING",
+}
+
+func init() {
+	Register("net", func(c *config.AgentConfig) (Collector, error) {
+		return newNetCollector(c.NetInterfaces, c.NetInterfacesExclude)
+	})
+}
+
+// netCollector reports the traffic, errors and drops of the network interfaces passing
+// the filter and the number of TCP connections in each state.
+type netCollector struct {
+	interfaces nameFilter
+	io         counterDeltas
+}
+
+func newNetCollector(include, exclude string) (*netCollector, error) {
+	interfaces, err := newNameFilter(include, exclude)
+	if err != nil {
+		return nil, fmt.Errorf("interfaces: %w", err)
+	}
+
+	return &netCollector{interfaces: interfaces}, nil
+}
+
+func (c *netCollector) Collect(ctx context.Context, r Recorder) error {
+	var errs []error
+
+	stats, err := net.IOCountersWithContext(ctx, true)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error with net io counters %w", err))
+	} else {
+		totals := make(map[string]uint64, len(stats)*8)
+		for _, stat := range stats {
+			if !c.interfaces.match(stat.Name) {
+				continue
+			}
+			totals[metricName("NetBytesSent", stat.Name)] = stat.BytesSent
+			totals[metricName("NetBytesRecv", stat.Name)] = stat.BytesRecv
+			totals[metricName("NetPacketsSent", stat.Name)] = stat.PacketsSent
+			totals[metricName("NetPacketsRecv", stat.Name)] = stat.PacketsRecv
+			totals[metricName("NetErrIn", stat.Name)] = stat.Errin
+			totals[metricName("NetErrOut", stat.Name)] = stat.Errout
+			totals[metricName("NetDropIn", stat.Name)] = stat.Dropin
+			totals[metricName("NetDropOut", stat.Name)] = stat.Dropout
+		}
+		errs = append(errs, counters(ctx, r, c.io.next(totals)))
+	}
+
+	// Without uids the connections come from /proc/net alone, no process is looked at.
+	conns, err := net.ConnectionsWithoutUidsWithContext(ctx, "tcp")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error with tcp connections %w", err))
+	} else {
+		states := make(map[string]metrics.Gauge, len(tcpStates))
+		for _, state := range tcpStates {
+			states[metricName("TCPConnections", state)] = 0
+		}
+		for _, conn := range conns {
+			states[metricName("TCPConnections", conn.Status)]++
+		}
+		errs = append(errs, gauges(ctx, r, states))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,259 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+const (
+	matchName    = "name"
+	matchCmdline = "cmdline"
+	matchPidfile = "pidfile"
+)
+
+func init() {
+	Register("process", func(c *config.AgentConfig) (Collector, error) {
+		return newProcessCollector(c.Processes)
+	})
+}
+
+// processCollector reports the processes of every configured group together: their
+// number, resident memory, CPU%, open files and threads, and how many of them were
+// started since the previous poll.
+type processCollector struct {
+	groups []*processGroup
+}
+
+// processGroup matches processes by name, by a regular expression on the command
+// line or by the pid in a pidfile.
+type processGroup struct {
+	name    string
+	kind    string
+	pattern string
+	re      *regexp.Regexp
+
+	polled   bool
+	lastPoll time.Time
+	lastSeen map[processKey]bool
+	lastCPU  map[processKey]float64
+}
+
+// processKey tells a restarted process from an old one with the same pid.
+type processKey struct {
+	pid     int32
+	created int64
+}
+
+// newProcessCollector reads groups like "web=name:nginx;api=cmdline:^/usr/bin/api ".
+// Groups are separated by semicolons since regular expressions may contain commas.
+func newProcessCollector(spec string) (*processCollector, error) {
+	c := &processCollector{}
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		name, matcher, ok := strings.Cut(entry, "=")
+		kind, pattern, ok2 := strings.Cut(matcher, ":")
+		name = strings.TrimSpace(name)
+		if !ok || !ok2 || name == "" || pattern == "" || seen[name] {
+			return nil, fmt.Errorf("invalid process group %q, want name=kind:pattern", entry)
+		}
+		seen[name] = true
+
+		g := &processGroup{name: name, kind: strings.TrimSpace(kind), pattern: pattern}
+		switch g.kind {
+		case matchName, matchPidfile:
+		case matchCmdline:
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("process group %s: %w", name, err)
+			}
+			g.re = re
+		default:
+			return nil, fmt.Errorf("process group %s: unknown match %q, want name, cmdline or pidfile", name, kind)
+		}
+		c.groups = append(c.groups, g)
+	}
+	if len(c.groups) == 0 {
+		return nil, errors.New("no process groups configured")
+	}
+
+	return c, nil
+}
+
+func (c *processCollector) Collect(ctx context.Context, r Recorder) error {
+	var (
+		errs    []error
+		procs   []*process.Process
+		listErr error
+		listed  bool
+	)
+	for _, g := range c.groups {
+		if g.kind != matchPidfile && !listed {
+			listed = true
+			if procs, listErr = process.ProcessesWithContext(ctx); listErr != nil {
+				errs = append(errs, fmt.Errorf("error with processes %w", listErr))
+			}
+		}
+		if g.kind != matchPidfile && listErr != nil {
+			continue
+		}
+
+		if err := g.collect(ctx, r, procs); err != nil {
+			errs = append(errs, fmt.Errorf("process group %s: %w", g.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (g *processGroup) collect(ctx context.Context, r Recorder, procs []*process.Process) error {
+	matched, err := g.match(ctx, procs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(g.lastPoll).Seconds()
+
+	var (
+		readErrs               []error
+		rss, cpuPercent        float64
+		fds, threads, restarts int
+	)
+	seen := make(map[processKey]bool, len(matched))
+	cpuTimes := make(map[processKey]float64, len(matched))
+	for _, p := range matched {
+		created, err := p.CreateTimeWithContext(ctx)
+		if err != nil {
+			if !gone(err) {
+				readErrs = append(readErrs, err)
+			}
+			continue
+		}
+		key := processKey{pid: p.Pid, created: created}
+		seen[key] = true
+		if g.polled && !g.lastSeen[key] {
+			restarts++
+		}
+
+		if times, err := p.TimesWithContext(ctx); err == nil {
+			cpuTimes[key] = times.User + times.System
+			if last, ok := g.lastCPU[key]; ok && elapsed > 0 {
+				cpuPercent += max(cpuTimes[key]-last, 0) / elapsed * 100
+			}
+		} else if !gone(err) {
+			readErrs = append(readErrs, err)
+		}
+		if mem, err := p.MemoryInfoWithContext(ctx); err == nil {
+			rss += float64(mem.RSS)
+		} else if !gone(err) {
+			readErrs = append(readErrs, err)
+		}
+		if n, err := p.NumFDsWithContext(ctx); err == nil {
+			fds += int(n)
+		} else if !gone(err) {
+			readErrs = append(readErrs, err)
+		}
+		if n, err := p.NumThreadsWithContext(ctx); err == nil {
+			threads += int(n)
+		} else if !gone(err) {
+			readErrs = append(readErrs, err)
+		}
+	}
+
+	errs := []error{
+		gauges(ctx, r, map[string]metrics.Gauge{
+			metricName("ProcessCount", g.name):   metrics.Gauge(len(seen)),
+			metricName("ProcessRSS", g.name):     metrics.Gauge(rss),
+			metricName("ProcessCPU", g.name):     metrics.Gauge(cpuPercent),
+			metricName("ProcessFDs", g.name):     metrics.Gauge(fds),
+			metricName("ProcessThreads", g.name): metrics.Gauge(threads),
+		}),
+		r.UpdateCounterMetric(ctx, metricName("ProcessRestarts", g.name), metrics.Counter(restarts)),
+	}
+	// Reads usually fail for every process of a group alike, one of them is enough.
+	if len(readErrs) > 0 {
+		errs = append(errs, fmt.Errorf("%d reads failed, first %w", len(readErrs), readErrs[0]))
+	}
+
+	g.polled = true
+	g.lastPoll = now
+	g.lastSeen = seen
+	g.lastCPU = cpuTimes
+
+	return errors.Join(errs...)
+}
+
+func (g *processGroup) match(ctx context.Context, procs []*process.Process) ([]*process.Process, error) {
+	if g.kind == matchPidfile {
+		return g.matchPidfile(ctx)
+	}
+
+	var matched []*process.Process
+	for _, p := range procs {
+		var (
+			value string
+			err   error
+		)
+		if g.kind == matchName {
+			value, err = p.NameWithContext(ctx)
+		} else {
+			value, err = p.CmdlineWithContext(ctx)
+		}
+		if err != nil {
+			continue
+		}
+
+		if (g.kind == matchName && value == g.pattern) || (g.kind == matchCmdline && g.re.MatchString(value)) {
+			matched = append(matched, p)
+		}
+	}
+
+	return matched, nil
+}
+
+// matchPidfile finds the process written in the pidfile. A missing pidfile or a
+// process that is gone means the service is down, not that the collector failed.
+func (g *processGroup) matchPidfile(ctx context.Context) ([]*process.Process, error) {
+	data, err := os.ReadFile(g.pattern)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pidfile %s: %w", g.pattern, err)
+	}
+
+	p, err := process.NewProcessWithContext(ctx, int32(pid))
+	if gone(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []*process.Process{p}, nil
+}
+
+// gone reports whether err means the process exited while it was being read.
+func gone(err error) bool {
+	return errors.Is(err, process.ErrorProcessNotRunning) || errors.Is(err, fs.ErrNotExist)
+}
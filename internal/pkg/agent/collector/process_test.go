@@ -0,0 +1,30 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProcessCollector(t *testing.T) {
+	c, err := newProcessCollector("web=name:nginx; api=cmdline:^/usr/bin/api (a|b),c;db=pidfile:/run/db.pid;")
+	require.NoError(t, err)
+	require.Len(t, c.groups, 3)
+	assert.Equal(t, "web", c.groups[0].name)
+	assert.Equal(t, matchName, c.groups[0].kind)
+	assert.True(t, c.groups[1].re.MatchString("/usr/bin/api a,c"))
+	assert.Equal(t, "/run/db.pid", c.groups[2].pattern)
+
+	for _, spec := range []string{
+		"",
+		"web",
+		"web=nginx",
+		"web=port:80",
+		"api=cmdline:(",
+		"web=name:nginx;web=name:httpd",
+	} {
+		_, err := newProcessCollector(spec)
+		assert.Error(t, err, spec)
+	}
+}
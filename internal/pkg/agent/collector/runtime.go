@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"runtime"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+)
+
+const PollCount = "PollCount"
+
+func init() {
+	Register("runtime", func(*config.AgentConfig) (Collector, error) {
+		return CollectorFunc(collectRuntime), nil
+	})
+}
+
+// collectRuntime reports the Go memory statistics of the agent, a random value and
+// the number of polls.
+func collectRuntime(ctx context.Context, r Recorder) error {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	err := gauges(ctx, r, map[string]metrics.Gauge{
+		"Alloc":         metrics.Gauge(stats.Alloc),
+		"BuckHashSys":   metrics.Gauge(stats.BuckHashSys),
+		"Frees":         metrics.Gauge(stats.Frees),
+		"GCCPUFraction": metrics.Gauge(stats.GCCPUFraction),
+		"GCSys":         metrics.Gauge(stats.GCSys),
+		"HeapAlloc":     metrics.Gauge(stats.HeapAlloc),
+		"HeapIdle":      metrics.Gauge(stats.HeapIdle),
+		"HeapInuse":     metrics.Gauge(stats.HeapInuse),
+		"HeapObjects":   metrics.Gauge(stats.HeapObjects),
+		"HeapReleased":  metrics.Gauge(stats.HeapReleased),
+		"HeapSys":       metrics.Gauge(stats.HeapSys),
+		"Lookups":       metrics.Gauge(stats.Lookups),
+		"MCacheInuse":   metrics.Gauge(stats.MCacheInuse),
+		"MCacheSys":     metrics.Gauge(stats.MCacheSys),
+		"MSpanInuse":    metrics.Gauge(stats.MSpanInuse),
+		"MSpanSys":      metrics.Gauge(stats.MSpanSys),
+		"Mallocs":       metrics.Gauge(stats.Mallocs),
+		"NextGC":        metrics.Gauge(stats.NextGC),
+		"LastGC":        metrics.Gauge(stats.LastGC),
+		"NumForcedGC":   metrics.Gauge(stats.NumForcedGC),
+		"NumGC":         metrics.Gauge(stats.NumGC),
+		"OtherSys":      metrics.Gauge(stats.OtherSys),
+		"PauseTotalNs":  metrics.Gauge(stats.PauseTotalNs),
+		"StackInuse":    metrics.Gauge(stats.StackInuse),
+		"StackSys":      metrics.Gauge(stats.StackSys),
+		"Sys":           metrics.Gauge(stats.Sys),
+		"TotalAlloc":    metrics.Gauge(stats.TotalAlloc),
+		"RandomValue":   metrics.Gauge(rand.Float64()),
+	})
+
+	return errors.Join(err, r.UpdateCounterMetric(ctx, PollCount, 1))
+}
@@ -2,13 +2,17 @@ package config
 
 import (
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/caarlos0/env/v6"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/certs"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/keys"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,8 +24,42 @@ type AgentConfig struct {
 	RateLimit      int    `env:"RATE_LIMIT"`
 	PublicKeyPath  string `env:"CRYPTO_KEY" json:"crypto_key"`
 	PublicKey      *rsa.PublicKey
+	PublicKeyID    string
 	ConfigPath     string `env:"CONFIG"`
 	SignKeyByte    []byte
+	TLS            bool   `env:"TLS" json:"tls"`
+	TLSCAFile      string `env:"TLS_CA" json:"tls_ca"`
+	TLSCertFile    string `env:"TLS_CERT" json:"tls_cert"`
+	TLSKeyFile     string `env:"TLS_KEY" json:"tls_key"`
+	Token          string `env:"TOKEN" json:"token"`
+	Transport      string `env:"TRANSPORT" json:"transport"`
+	GRPCAddress    string `env:"GRPC_ADDRESS" json:"grpc_address"`
+	SpoolDir       string `env:"SPOOL_DIR" json:"spool_dir"`
+	SpoolMaxSize   int64  `env:"SPOOL_MAX_SIZE" json:"spool_max_size"`
+	SpoolMaxAge    int    `env:"SPOOL_MAX_AGE" json:"spool_max_age"`
+	RequestTimeout int    `env:"REQUEST_TIMEOUT" json:"request_timeout"`
+	RetryAttempts  int    `env:"RETRY_ATTEMPTS" json:"retry_attempts"`
+	RetryMaxDelay  int    `env:"RETRY_MAX_DELAY" json:"retry_max_delay"`
+	Collectors     string `env:"COLLECTORS" json:"collectors"`
+	CollectorPolls []CollectorPoll
+	Certs          *certs.Reloader
+
+	// Disk collector, comma separated mountpoint patterns.
+	DiskMounts        string `env:"DISK_MOUNTS" json:"disk_mounts"`
+	DiskMountsExclude string `env:"DISK_MOUNTS_EXCLUDE" json:"disk_mounts_exclude"`
+
+	// Net collector, comma separated interface patterns.
+	NetInterfaces        string `env:"NET_INTERFACES" json:"net_interfaces"`
+	NetInterfacesExclude string `env:"NET_INTERFACES_EXCLUDE" json:"net_interfaces_exclude"`
+
+	// Process collector, semicolon separated groups like "web=name:nginx".
+	Processes string `env:"PROCESSES" json:"processes"`
+}
+
+// CollectorPoll enables a collector, Interval is in seconds, zero means the poll interval.
+type CollectorPoll struct {
+	Name     string
+	Interval int
 }
 
 const (
@@ -29,21 +67,32 @@ const (
 	reportIntervalDefault = 10
 	pollIntervalDefault   = 2
 	rateLimitDefault      = 3
+	spoolMaxSizeDefault   = 64 << 20
+	spoolMaxAgeDefault    = 24 * 60 * 60
+	requestTimeoutDefault = 10
+	retryAttemptsDefault  = 3
+	retryMaxDelayDefault  = 30
+	collectorsDefault     = "runtime,memory,cpu,disk,net"
+)
+
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
 )
 
 func NewAgentConfig() (*AgentConfig, error) {
 	cfg := AgentConfig{}
 	cfg.init()
 
-	if cfg.SignKey != "" {
-		cfg.SignKeyByte = []byte(cfg.SignKey)
-	}
-
 	if err := env.Parse(&cfg); err != nil {
 		logrus.Errorf("env parsing error: %v", err)
 		return nil, err
 	}
 
+	if cfg.SignKey != "" {
+		cfg.SignKeyByte = []byte(cfg.SignKey)
+	}
+
 	if cfg.ConfigPath != "" {
 		cfgJSON, err := readConfigFile(cfg.ConfigPath)
 		if err != nil {
@@ -51,12 +100,31 @@ func NewAgentConfig() (*AgentConfig, error) {
 		}
 	}
 
+	if cfg.Transport == TransportGRPC && cfg.GRPCAddress == "" {
+		return nil, errors.New("the gRPC transport requires a gRPC server address")
+	}
+
+	collectorPolls, err := parseCollectors(cfg.Collectors)
+	if err != nil {
+		return nil, err
+	}
+	cfg.CollectorPolls = collectorPolls
+
 	if cfg.PublicKeyPath != "" {
-		publicKey, err := cfg.getPublicKey()
+		publicKey, kid, err := cfg.getPublicKey()
 		if err != nil {
-			logrus.Errorf("error with get public key: %v", err)
+			return nil, fmt.Errorf("error with get public key: %w", err)
 		}
 		cfg.PublicKey = publicKey
+		cfg.PublicKeyID = kid
+	}
+
+	if cfg.useTLS() {
+		reloader, err := certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS certificates: %w", err)
+		}
+		cfg.Certs = reloader
 	}
 
 	return &cfg, nil
@@ -68,12 +136,76 @@ func (c *AgentConfig) init() {
 	flag.IntVar(&c.PollInterval, "p", pollIntervalDefault, "Interval of poll metric")
 	flag.StringVar(&c.SignKey, "k", "", "Server key")
 	flag.IntVar(&c.RateLimit, "l", rateLimitDefault, "Rate limit")
-	flag.StringVar(&c.PublicKeyPath, "-crypto-key", "", "Public key path")
+	flag.StringVar(&c.PublicKeyPath, "crypto-key", "", "Public key path")
+	flag.BoolVar(&c.TLS, "tls", false, "Send metrics over HTTPS")
+	flag.StringVar(&c.TLSCAFile, "tls-ca", "", "CA certificate path to verify the server (implies -tls)")
+	flag.StringVar(&c.TLSCertFile, "tls-cert", "", "Client certificate path (implies -tls)")
+	flag.StringVar(&c.TLSKeyFile, "tls-key", "", "Client certificate key path")
+	flag.StringVar(&c.Token, "token", "", "API token sent as a bearer token")
+	flag.StringVar(&c.Transport, "transport", TransportHTTP, "Transport to send metrics with: http or grpc, falling back to http")
+	flag.StringVar(&c.GRPCAddress, "grpc-address", "", "gRPC server address for the grpc transport")
+	flag.StringVar(&c.SpoolDir, "spool-dir", "", "Directory to keep unsent batches in until the server is back")
+	flag.Int64Var(&c.SpoolMaxSize, "spool-max-size", spoolMaxSizeDefault, "Spool size in bytes before old batches are merged")
+	flag.IntVar(&c.SpoolMaxAge, "spool-max-age", spoolMaxAgeDefault, "Age in seconds after which spooled batches are dropped")
+	flag.IntVar(&c.RequestTimeout, "request-timeout", requestTimeoutDefault, "Timeout of a single send in seconds")
+	flag.IntVar(&c.RetryAttempts, "retries", retryAttemptsDefault, "Retries of a failed send before giving up")
+	flag.IntVar(&c.RetryMaxDelay, "retry-max-delay", retryMaxDelayDefault, "Longest wait between retries in seconds")
+	flag.StringVar(&c.Collectors, "collectors", collectorsDefault,
+		"Collectors to run as name or name:seconds to poll it at its own interval, comma separated")
+	flag.StringVar(&c.DiskMounts, "disk-mounts", "", "Mountpoint patterns the disk collector reports, all when empty")
+	flag.StringVar(&c.DiskMountsExclude, "disk-mounts-exclude", "", "Mountpoint patterns the disk collector skips")
+	flag.StringVar(&c.NetInterfaces, "net-interfaces", "", "Interface patterns the net collector reports, all when empty")
+	flag.StringVar(&c.NetInterfacesExclude, "net-interfaces-exclude", "", "Interface patterns the net collector skips")
+	flag.StringVar(&c.Processes, "processes", "",
+		"Process groups the process collector reports as group=name:NAME, group=cmdline:REGEXP or group=pidfile:PATH separated by semicolons")
 	flag.StringVar(&c.ConfigPath, "c", "", "Path to config file")
 	flag.StringVar(&c.ConfigPath, "config", "", "Path to config file (the same as -c)")
 	flag.Parse()
 }
 
+func (c *AgentConfig) useTLS() bool {
+	return c.TLS || c.TLSCAFile != "" || c.TLSCertFile != ""
+}
+
+// URL builds the server URL for path with the scheme matching the TLS settings.
+func (c *AgentConfig) URL(path string) string {
+	scheme := "http"
+	if c.useTLS() {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, c.ServerAddress, path)
+}
+
+// parseCollectors reads a list like "runtime,cpu:5". A collector left out is disabled.
+func parseCollectors(spec string) ([]CollectorPoll, error) {
+	var polls []CollectorPoll
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, interval, hasInterval := strings.Cut(entry, ":")
+		poll := CollectorPoll{Name: strings.TrimSpace(name)}
+		if hasInterval {
+			seconds, err := strconv.Atoi(strings.TrimSpace(interval))
+			if err != nil || seconds <= 0 {
+				return nil, fmt.Errorf("invalid interval of collector %s: %q", poll.Name, interval)
+			}
+			poll.Interval = seconds
+		}
+		if poll.Name == "" || seen[poll.Name] {
+			return nil, fmt.Errorf("invalid collector list %q", spec)
+		}
+		seen[poll.Name] = true
+		polls = append(polls, poll)
+	}
+
+	return polls, nil
+}
+
 func readConfigFile(path string) (cfg *AgentConfig, err error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -88,21 +220,21 @@ func readConfigFile(path string) (cfg *AgentConfig, err error) {
 	return cfg, nil
 }
 
-func (c *AgentConfig) getPublicKey() (*rsa.PublicKey, error) {
+func (c *AgentConfig) getPublicKey() (*rsa.PublicKey, string, error) {
 	publicKeyPEM, err := os.ReadFile(c.PublicKeyPath)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	publicKeyBlock, _ := pem.Decode(publicKeyPEM)
-	if publicKeyBlock == nil {
-		return nil, err
+	publicKey, kid, err := keys.ParsePublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, "", err
 	}
 
-	publicKey, err := x509.ParsePKCS1PublicKey(publicKeyBlock.Bytes)
-	if err != nil {
-		return nil, err
+	rsaKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, "", fmt.Errorf("payload encryption requires an RSA key, got %s", keys.Describe(publicKey))
 	}
 
-	return publicKey, nil
+	return rsaKey, kid, nil
 }
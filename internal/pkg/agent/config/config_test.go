@@ -34,6 +34,16 @@ func TestNewAgentConfig(t *testing.T) {
 				ReportInterval: 10,
 				PollInterval:   2,
 				RateLimit:      3,
+				Transport:      TransportHTTP,
+				SpoolMaxSize:   64 << 20,
+				SpoolMaxAge:    86400,
+				RequestTimeout: 10,
+				RetryAttempts:  3,
+				RetryMaxDelay:  30,
+				Collectors:     "runtime,memory,cpu,disk,net",
+				CollectorPolls: []CollectorPoll{
+					{Name: "runtime"}, {Name: "memory"}, {Name: "cpu"}, {Name: "disk"}, {Name: "net"},
+				},
 			},
 		},
 	}
@@ -45,3 +55,34 @@ func TestNewAgentConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCollectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []CollectorPoll
+		wantErr bool
+	}{
+		{name: "empty", spec: ""},
+		{
+			name: "intervals",
+			spec: "runtime, cpu:5,disk:60",
+			want: []CollectorPoll{{Name: "runtime"}, {Name: "cpu", Interval: 5}, {Name: "disk", Interval: 60}},
+		},
+		{name: "bad interval", spec: "cpu:fast", wantErr: true},
+		{name: "zero interval", spec: "cpu:0", wantErr: true},
+		{name: "duplicate", spec: "cpu,cpu:5", wantErr: true},
+		{name: "no name", spec: ":5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCollectors(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCollectors() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCollectors() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
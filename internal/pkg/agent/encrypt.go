@@ -1,15 +1,11 @@
 package agent
 
 import (
-	"crypto/rand"
 	"crypto/rsa"
-)
 
-func encrypt(key *rsa.PublicKey, data []byte) ([]byte, error) {
-	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, key, data)
-	if err != nil {
-		return nil, err
-	}
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/envelope"
+)
 
-	return ciphertext, nil
+func encrypt(key *rsa.PublicKey, kid string, data []byte) ([]byte, error) {
+	return envelope.Seal(key, kid, data)
 }
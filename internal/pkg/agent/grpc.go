@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	pbv2 "github.com/mayr0y/animated-octo-couscous.git/api/serverv2"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	metricsgrpc "github.com/mayr0y/animated-octo-couscous.git/internal/pkg/server/grpc"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	keepaliveTime    = 30 * time.Second
+	keepaliveTimeout = 10 * time.Second
+)
+
+// GRPCTransport sends batches with the UpdateMetricsBatch call of the v2 Metrics
+// service over one persistent connection. Payload encryption is HTTP only, use TLS
+// to protect gRPC traffic.
+type GRPCTransport struct {
+	c      *config.AgentConfig
+	conn   *grpc.ClientConn
+	client pbv2.MetricsClient
+}
+
+func NewGRPCTransport(c *config.AgentConfig, opts ...grpc.DialOption) (*GRPCTransport, error) {
+	creds := insecure.NewCredentials()
+	if c.Certs != nil {
+		creds = credentials.NewTLS(c.Certs.ClientConfig(c.GRPCAddress))
+	}
+
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+	}, opts...)
+
+	conn, err := grpc.Dial(c.GRPCAddress, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connect to gRPC server %s: %w", c.GRPCAddress, err)
+	}
+
+	return &GRPCTransport{
+		c:      c,
+		conn:   conn,
+		client: pbv2.NewMetricsClient(conn),
+	}, nil
+}
+
+func (t *GRPCTransport) Send(ctx context.Context, batch []*metrics.Metrics) error {
+	req := &pbv2.UpdateMetricsBatchRequest{Metrics: make([]*pbv2.Metric, 0, len(batch))}
+	for _, m := range batch {
+		req.Metrics = append(req.Metrics, toProto(m))
+	}
+
+	if t.c.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(t.c.RequestTimeout)*time.Second)
+		defer cancel()
+	}
+
+	if err := t.connect(ctx); err != nil {
+		return err
+	}
+
+	ctx, err := t.outgoingContext(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.client.UpdateMetricsBatch(ctx, req)
+	if err != nil {
+		return fmt.Errorf("update metrics batch: %w", err)
+	}
+
+	rejected := &RejectedError{Total: len(batch)}
+	results := resp.GetResults()
+	for i, result := range results {
+		st := status.FromProto(result.GetStatus())
+		if st.Code() == codes.OK {
+			continue
+		}
+
+		metric := findMetric(batch, i, len(results), result.GetId())
+		if metric == nil {
+			return fmt.Errorf("result for unknown metric %s: %w", result.GetId(), st.Err())
+		}
+		rejected.Rejected = append(rejected.Rejected, RejectedMetric{Metric: metric, Err: st.Err()})
+	}
+	if len(rejected.Rejected) > 0 {
+		return rejected
+	}
+
+	return nil
+}
+
+// connect waits until the connection is ready, so that a server that can't be reached
+// is told apart from a call that failed after the server may have handled it.
+func (t *GRPCTransport) connect(ctx context.Context) error {
+	for {
+		state := t.conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Idle:
+			t.conn.Connect()
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return fmt.Errorf("%w: %s is %s", ErrNotConnected, t.c.GRPCAddress, state)
+		}
+
+		if !t.conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("%w: %w", ErrNotConnected, ctx.Err())
+		}
+	}
+}
+
+func (t *GRPCTransport) outgoingContext(ctx context.Context, req *pbv2.UpdateMetricsBatchRequest) (context.Context, error) {
+	var pairs []string
+	if t.c.Token != "" {
+		pairs = append(pairs, metricsgrpc.AuthorizationMetadataKey, "Bearer "+t.c.Token)
+	}
+
+	host, _, err := net.SplitHostPort(t.c.GRPCAddress)
+	if err != nil {
+		host = t.c.GRPCAddress
+	}
+	if ip, err := outboundIP(host); err == nil {
+		pairs = append(pairs, metricsgrpc.RealIPMetadataKey, ip.String())
+	} else {
+		logrus.Warnf("Cannot determine outbound address: %v", err)
+	}
+
+	if t.c.SignKeyByte != nil {
+		nonce, err := sign.NewNonce()
+		if err != nil {
+			return nil, fmt.Errorf("error generate nonce %w", err)
+		}
+		timestamp := sign.Timestamp(time.Now())
+		signature, err := sign.SumMessages(t.c.SignKeyByte, timestamp, nonce, req)
+		if err != nil {
+			return nil, fmt.Errorf("error sign request %w", err)
+		}
+		pairs = append(pairs,
+			metricsgrpc.HashMetadataKey, signature,
+			metricsgrpc.TimestampMetadataKey, timestamp,
+			metricsgrpc.NonceMetadataKey, nonce,
+		)
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, pairs...), nil
+}
+
+func (t *GRPCTransport) Close() error {
+	return t.conn.Close()
+}
+
+// findMetric returns the metric a result is for. Results come in the order of the
+// batch, the id guards against a server that doesn't keep it.
+func findMetric(batch []*metrics.Metrics, i, results int, id string) *metrics.Metrics {
+	if results == len(batch) && batch[i].ID == id {
+		return batch[i]
+	}
+	for _, m := range batch {
+		if m.ID == id {
+			return m
+		}
+	}
+
+	return nil
+}
+
+func toProto(m *metrics.Metrics) *pbv2.Metric {
+	metric := &pbv2.Metric{
+		Id:   m.ID,
+		Type: m.MType,
+	}
+	if m.Value != nil {
+		metric.Value = float64(*m.Value)
+	}
+	if m.Delta != nil {
+		metric.Delta = int64(*m.Delta)
+	}
+
+	return metric
+}
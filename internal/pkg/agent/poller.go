@@ -2,101 +2,103 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math/rand"
-	"runtime"
-	"strings"
+	"runtime/debug"
+	"sync"
 	"time"
 
-	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
-	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/collector"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	PollCount = "PollCount"
+	PollCount = collector.PollCount
 )
 
-func runUpdateMemStatMetrics(ctx context.Context, pollerTicker *time.Ticker, s storage.Store) {
+// defaultCollectors are run by UpdateMetrics.
+var defaultCollectors = []string{"runtime", "memory", "cpu"}
+
+type scheduledCollector struct {
+	name      string
+	collector collector.Collector
+	interval  time.Duration
+}
+
+// newCollectors builds the collectors enabled in c.
+func newCollectors(c *config.AgentConfig) ([]scheduledCollector, error) {
+	collectors := make([]scheduledCollector, 0, len(c.CollectorPolls))
+	for _, poll := range c.CollectorPolls {
+		col, err := collector.New(poll.Name, c)
+		if err != nil {
+			return nil, err
+		}
+
+		interval := time.Duration(c.PollInterval) * time.Second
+		if poll.Interval > 0 {
+			interval = time.Duration(poll.Interval) * time.Second
+		}
+		collectors = append(collectors, scheduledCollector{name: poll.Name, collector: col, interval: interval})
+	}
+
+	return collectors, nil
+}
+
+// runCollectors polls every collector on its own ticker, so a slow or failing one
+// doesn't hold back the others.
+func runCollectors(ctx context.Context, collectors []scheduledCollector, r collector.Recorder) {
+	wg := &sync.WaitGroup{}
+	for _, sc := range collectors {
+		wg.Add(1)
+		go func(sc scheduledCollector) {
+			defer wg.Done()
+			runCollector(ctx, sc, r)
+		}(sc)
+	}
+	wg.Wait()
+}
+
+func runCollector(ctx context.Context, sc scheduledCollector, r collector.Recorder) {
+	ticker := time.NewTicker(sc.interval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-pollerTicker.C:
-			if err := UpdateMetrics(ctx, s); err != nil {
-				logrus.Errorf("Error update mem stat metrics %v", err)
+		case <-ticker.C:
+			if err := collect(ctx, sc, r); err != nil {
+				logrus.Errorf("Error collect %s metrics: %v", sc.name, err)
 			}
 		}
 	}
 }
 
-func UpdateMetrics(ctx context.Context, m storage.Store) error {
-	var metricsStats runtime.MemStats
-	runtime.ReadMemStats(&metricsStats)
-
-	gaugeMetricsMap := make(map[string]metrics.Gauge)
-	gaugeMetricsMap["Alloc"] = metrics.Gauge(metricsStats.Alloc)
-	gaugeMetricsMap["BuckHashSys"] = metrics.Gauge(metricsStats.BuckHashSys)
-	gaugeMetricsMap["Frees"] = metrics.Gauge(metricsStats.Frees)
-	gaugeMetricsMap["GCCPUFraction"] = metrics.Gauge(metricsStats.GCCPUFraction)
-	gaugeMetricsMap["GCSys"] = metrics.Gauge(metricsStats.GCSys)
-	gaugeMetricsMap["HeapAlloc"] = metrics.Gauge(metricsStats.HeapAlloc)
-	gaugeMetricsMap["HeapIdle"] = metrics.Gauge(metricsStats.HeapIdle)
-	gaugeMetricsMap["HeapInuse"] = metrics.Gauge(metricsStats.HeapInuse)
-	gaugeMetricsMap["HeapObjects"] = metrics.Gauge(metricsStats.HeapObjects)
-	gaugeMetricsMap["HeapReleased"] = metrics.Gauge(metricsStats.HeapReleased)
-	gaugeMetricsMap["HeapSys"] = metrics.Gauge(metricsStats.HeapSys)
-	gaugeMetricsMap["Lookups"] = metrics.Gauge(metricsStats.Lookups)
-	gaugeMetricsMap["MCacheInuse"] = metrics.Gauge(metricsStats.MCacheInuse)
-	gaugeMetricsMap["MCacheSys"] = metrics.Gauge(metricsStats.MCacheSys)
-	gaugeMetricsMap["MSpanInuse"] = metrics.Gauge(metricsStats.MSpanInuse)
-	gaugeMetricsMap["MSpanSys"] = metrics.Gauge(metricsStats.MSpanSys)
-	gaugeMetricsMap["Mallocs"] = metrics.Gauge(metricsStats.Mallocs)
-	gaugeMetricsMap["NextGC"] = metrics.Gauge(metricsStats.NextGC)
-	gaugeMetricsMap["LastGC"] = metrics.Gauge(metricsStats.LastGC)
-	gaugeMetricsMap["NumForcedGC"] = metrics.Gauge(metricsStats.NumForcedGC)
-	gaugeMetricsMap["NumGC"] = metrics.Gauge(metricsStats.NumGC)
-	gaugeMetricsMap["OtherSys"] = metrics.Gauge(metricsStats.OtherSys)
-	gaugeMetricsMap["PauseTotalNs"] = metrics.Gauge(metricsStats.PauseTotalNs)
-	gaugeMetricsMap["StackInuse"] = metrics.Gauge(metricsStats.StackInuse)
-	gaugeMetricsMap["StackSys"] = metrics.Gauge(metricsStats.StackSys)
-	gaugeMetricsMap["Sys"] = metrics.Gauge(metricsStats.Sys)
-	gaugeMetricsMap["TotalAlloc"] = metrics.Gauge(metricsStats.TotalAlloc)
-	gaugeMetricsMap["RandomValue"] = metrics.Gauge(rand.Float64())
-
-	vm, err := mem.VirtualMemory()
-	if err != nil {
-		return fmt.Errorf("error with virtualMemory %w", err)
-	}
-
-	cpuMetric, err := cpu.Percent(time.Duration(1)*time.Second, true)
-	if err != nil {
-		return fmt.Errorf("error with cpu %w", err)
-	}
-
-	gaugeMetricsMap["TotalMemory"] = metrics.Gauge(vm.Total)
-	gaugeMetricsMap["FreeMemory"] = metrics.Gauge(vm.Free)
-	gaugeMetricsMap["CPUutilization1"] = metrics.Gauge(cpuMetric[0])
+// collect runs the collector once and turns a panic into an error, so that a broken
+// collector keeps being polled and the agent keeps running.
+func collect(ctx context.Context, sc scheduledCollector, r collector.Recorder) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+		}
+	}()
 
-	var errorsSlice []string
+	return sc.collector.Collect(ctx, r)
+}
 
-	for k, v := range gaugeMetricsMap {
-		err = m.UpdateGaugeMetric(ctx, k, v)
+// UpdateMetrics runs the default collectors once.
+func UpdateMetrics(ctx context.Context, m collector.Recorder) error {
+	var errs []error
+	for _, name := range defaultCollectors {
+		col, err := collector.New(name, &config.AgentConfig{})
+		if err == nil {
+			err = col.Collect(ctx, m)
+		}
 		if err != nil {
-			errorsSlice = append(errorsSlice, err.Error())
+			errs = append(errs, err)
 		}
 	}
 
-	err = m.UpdateCounterMetric(ctx, PollCount, 1)
-	if err != nil {
-		errorsSlice = append(errorsSlice, err.Error())
-	}
-
-	if len(errorsSlice) > 0 {
-		return fmt.Errorf(strings.Join(errorsSlice, "\n"))
-	} else {
-		return nil
-	}
+	return errors.Join(errs...)
 }
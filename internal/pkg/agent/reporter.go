@@ -4,67 +4,166 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
-	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
 	"github.com/sirupsen/logrus"
 )
 
-func RunSendMetric(ctx context.Context, reportTicker *time.Ticker, c *config.AgentConfig, s storage.Store) {
+// RunReporter takes a batch from b on every tick and hands it to one of workers senders.
+// A tick that finds all of them busy is skipped, its counter increments go with the next
+// batch. When ctx is done the last batch is sent too and the senders get drainTimeout
+// to finish.
+func RunReporter(
+	ctx context.Context,
+	ticks <-chan time.Time,
+	t Transport,
+	b *Buffer,
+	workers int,
+	drainTimeout time.Duration,
+) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	sendCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
+	batches := make(chan []*metrics.Metrics)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				if err := sendBatch(sendCtx, b, t, batch); err != nil {
+					logrus.Errorf("Error send metrics %v", err)
+				}
+			}
+		}()
+	}
+
+	produceBatches(ctx, ticks, b, batches)
+
+	timer := time.AfterFunc(drainTimeout, cancel)
+	defer timer.Stop()
+
+	if batch := b.Snapshot(); len(batch) > 0 {
+		batches <- batch
+	}
+	close(batches)
+	wg.Wait()
+}
+
+func produceBatches(ctx context.Context, ticks <-chan time.Time, b *Buffer, batches chan<- []*metrics.Metrics) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-reportTicker.C:
-			ok, err := SendMetrics(ctx, s, c)
-			if err != nil {
-				logrus.Errorf("Error send metrics %v", err)
+		case <-ticks:
+			batch := b.Snapshot()
+			if len(batch) == 0 {
+				continue
 			}
-			if ok {
-				if err = s.ResetCounterMetric(ctx, "PollCount"); err != nil {
-					logrus.Errorf("Error reset metrics %v", err)
-				}
+			select {
+			case batches <- batch:
+			default:
+				b.Restore(batch)
+				logrus.Warn("All senders are busy, metrics go with the next batch")
 			}
 		}
 	}
 }
 
-func SendMetrics(ctx context.Context, s storage.Store, c *config.AgentConfig) (bool, error) {
-	metricsMap, err := s.GetMetrics(ctx)
-	if err != nil {
-		logrus.Errorf("Some error ocured during metrics get: %q", err)
-		return false, err
-	}
+// SendMetrics reports the metrics of b over HTTP.
+func SendMetrics(ctx context.Context, b *Buffer, c *config.AgentConfig) error {
+	return sendMetrics(ctx, b, NewHTTPTransport(c))
+}
 
-	metricsBatch := make([]*metrics.Metrics, 0)
-	for _, v := range metricsMap {
-		metricsBatch = append(metricsBatch, v)
+// sendMetrics sends a snapshot of b and gives the counter increments back when the
+// send fails, so that they go with the next snapshot.
+func sendMetrics(ctx context.Context, b *Buffer, t Transport) error {
+	metricsBatch := b.Snapshot()
+	if len(metricsBatch) == 0 {
+		return nil
 	}
 
-	url := fmt.Sprintf("http://%s/updates/", c.ServerAddress)
+	return sendBatch(ctx, b, t, metricsBatch)
+}
+
+// sendBatch gives the counter deltas of a failed batch back to b only when the server
+// surely did not apply them, otherwise they would be counted twice. Deltas the server
+// refused for good or may have applied are dropped.
+func sendBatch(ctx context.Context, b *Buffer, t Transport, metricsBatch []*metrics.Metrics) error {
+	err := t.Send(ctx, metricsBatch)
+	if err == nil {
+		return nil
+	}
 
-	if err = SendBatchJSON(url, metricsBatch, c); err != nil {
-		return false, fmt.Errorf("error create post request %w", err)
+	var rejectedErr *RejectedError
+	switch {
+	case errors.As(err, &rejectedErr):
+		again := make([]*metrics.Metrics, 0, len(rejectedErr.Rejected))
+		for _, rejected := range rejectedErr.Rejected {
+			if notApplied(rejected.Err) {
+				again = append(again, rejected.Metric)
+			}
+		}
+		b.Restore(again)
+		return fmt.Errorf("error send metrics, %d of them go with the next batch: %w", len(again), err)
+	case notApplied(err):
+		b.Restore(metricsBatch)
+		return fmt.Errorf("error send metrics, they go with the next batch: %w", err)
+	default:
+		return fmt.Errorf("error send metrics, the batch is dropped: %w", err)
 	}
-	return true, nil
 }
 
 func SendBatchJSON(url string, metricsBatch []*metrics.Metrics, c *config.AgentConfig) error {
+	return sendBatchJSON(context.Background(), newHTTPClient(c), url, metricsBatch, c)
+}
+
+// newHTTPClient builds the client shared by all sends of an agent. Senders run in
+// parallel, so it keeps an idle connection for each of them.
+func newHTTPClient(c *config.AgentConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = c.RateLimit
+	transport.ResponseHeaderTimeout = time.Duration(c.RequestTimeout) * time.Second
+	if c.Certs != nil {
+		transport.TLSClientConfig = c.Certs.ClientConfig(c.ServerAddress)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(c.RequestTimeout) * time.Second,
+	}
+}
+
+func sendBatchJSON(
+	ctx context.Context,
+	client *http.Client,
+	url string,
+	metricsBatch []*metrics.Metrics,
+	c *config.AgentConfig,
+) error {
 	body, err := json.Marshal(metricsBatch)
 	if err != nil {
 		return fmt.Errorf("error encoding metric %w", err)
 	}
 
 	if c.PublicKeyPath != "" {
-		body, err = encrypt(c.PublicKey, body)
+		body, err = encrypt(c.PublicKey, c.PublicKeyID, body)
 		if err != nil {
 			return err
 		}
@@ -81,31 +180,89 @@ func SendBatchJSON(url string, metricsBatch []*metrics.Metrics, c *config.AgentC
 		return fmt.Errorf("error close %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, &buf)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &buf)
 	if err != nil {
 		return fmt.Errorf("error send request %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Content-Encoding", "gzip")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if ip, err := outboundIP(req.URL.Hostname()); err == nil {
+		req.Header.Set(subnet.RealIPHeader, ip.String())
+	} else {
+		logrus.Warnf("Cannot determine outbound address: %v", err)
+	}
 
+	var signature string
 	if c.SignKeyByte != nil {
-		h := hmac.New(sha256.New, c.SignKeyByte)
-		h.Write(body)
-		serverHash := hex.EncodeToString(h.Sum(nil))
-		req.Header.Set("HashSHA256", serverHash)
+		nonce, err := sign.NewNonce()
+		if err != nil {
+			return fmt.Errorf("error generate nonce %w", err)
+		}
+		timestamp := sign.Timestamp(time.Now())
+		signature = sign.SumRequest(c.SignKeyByte, timestamp, nonce, body)
+
+		req.Header.Set(sign.TimestampHeader, timestamp)
+		req.Header.Set(sign.NonceHeader, nonce)
+		req.Header.Set(sign.HashHeader, signature)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("error client %w", err)
 	}
 
 	defer resp.Body.Close()
 
+	// Refusals by the middlewares in front of the signature check are not signed, they
+	// can only make the agent send again, never accept a batch as delivered.
+	signed := resp.StatusCode == http.StatusOK || resp.Header.Get(sign.HashHeader) != ""
+	if c.SignKeyByte != nil && signed {
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error read response %w", err)
+		}
+		if !sign.VerifyResponse(c.SignKeyByte, resp.Header.Get(sign.HashHeader), resp.StatusCode, signature, respBody) {
+			return fmt.Errorf("%w, status code: %v", ErrResponseSignature, resp.StatusCode)
+		}
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("status code: %v and not 200", resp.StatusCode)
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
 
 	return nil
 }
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+
+	return 0
+}
+
+// outboundIP returns the local address used to reach host. Dialing UDP only selects
+// the route, no packets are sent.
+func outboundIP(host string) (net.IP, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(host, "9"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -7,12 +7,19 @@ import (
 	"net/http/httptest"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
-	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/middleware"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -24,7 +31,7 @@ const (
 
 func TestSendReport(t *testing.T) {
 	c, _ := config.NewAgentConfig()
-	mtr := storage.NewMetrics()
+	mtr := agent.NewBuffer()
 
 	err := agent.UpdateMetrics(context.Background(), mtr)
 	if err != nil {
@@ -73,3 +80,211 @@ func TestSendReport(t *testing.T) {
 
 	agent.SendMetrics(context.Background(), mtr, c)
 }
+
+func TestSendBatchJSONVerifiesResponse(t *testing.T) {
+	key := []byte("secret")
+	c := &config.AgentConfig{SignKeyByte: key}
+	delta := metrics.Counter(1)
+	batch := []*metrics.Metrics{{ID: "PollCount", MType: metrics.CounterMetricName, Delta: &delta}}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	}
+
+	isPost := func(r *http.Request) bool { return r.Method == http.MethodPost }
+	signed := httptest.NewServer(middleware.CompressMiddleware(
+		middleware.DecompressMiddleware(0)(middleware.CryptMiddleware(key, nil, isPost)(http.HandlerFunc(ok)))))
+	defer signed.Close()
+	if err := agent.SendBatchJSON(signed.URL+"/updates/", batch, c); err != nil {
+		t.Errorf("signed response was rejected: %v", err)
+	}
+
+	forged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(sign.HashHeader, r.Header.Get(sign.HashHeader))
+	}))
+	defer forged.Close()
+	if err := agent.SendBatchJSON(forged.URL+"/updates/", batch, c); err == nil {
+		t.Error("response with a copied request hash was accepted")
+	}
+}
+
+// blockingTransport holds every send until release is closed and tracks how many
+// sends run at once.
+type blockingTransport struct {
+	release chan struct{}
+
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+	sent        metrics.Counter
+}
+
+func (t *blockingTransport) Send(ctx context.Context, batch []*metrics.Metrics) error {
+	t.lock.Lock()
+	t.inFlight++
+	t.maxInFlight = max(t.maxInFlight, t.inFlight)
+	t.lock.Unlock()
+
+	defer func() {
+		t.lock.Lock()
+		t.inFlight--
+		t.lock.Unlock()
+	}()
+
+	select {
+	case <-t.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	count, _ := totals([][]*metrics.Metrics{batch})
+	t.sent += count
+
+	return nil
+}
+
+func (t *blockingTransport) Close() error {
+	return nil
+}
+
+func TestRunReporter(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			b := agent.NewBuffer()
+			transport := &blockingTransport{release: make(chan struct{})}
+			ticks := make(chan time.Time)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				agent.RunReporter(ctx, ticks, transport, b, workers, time.Second)
+			}()
+
+			var polls metrics.Counter
+			tick := func() {
+				require.NoError(t, b.UpdateCounterMetric(ctx, agent.PollCount, 1))
+				polls++
+				ticks <- time.Now()
+			}
+			inFlight := func() int {
+				transport.lock.Lock()
+				defer transport.lock.Unlock()
+				return transport.inFlight
+			}
+
+			// Tick until every sender is busy, then some more.
+			for inFlight() < workers {
+				tick()
+				time.Sleep(time.Millisecond)
+			}
+			for i := 0; i < 5; i++ {
+				tick()
+			}
+
+			cancel()
+			close(transport.release)
+			<-done
+
+			assert.Equal(t, workers, transport.maxInFlight)
+			assert.Equal(t, polls, transport.sent)
+		})
+	}
+}
+
+func TestRunReporterDrainTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := agent.NewBuffer()
+	require.NoError(t, b.UpdateCounterMetric(ctx, agent.PollCount, 1))
+	transport := &blockingTransport{release: make(chan struct{})}
+
+	cancel()
+	start := time.Now()
+	agent.RunReporter(ctx, nil, transport, b, 1, 10*time.Millisecond)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// The server may have got the batch that was cut short, its deltas are dropped
+	// rather than counted twice.
+	count, _ := totals([][]*metrics.Metrics{b.Snapshot()})
+	assert.Equal(t, metrics.Counter(0), count)
+}
+
+func TestSendKeepsOnlyUnappliedDeltas(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want metrics.Counter
+	}{
+		{name: "sent", want: 0},
+		{name: "unverified response", err: fmt.Errorf("error send: %w", agent.ErrResponseSignature), want: 0},
+		{name: "timeout", err: context.DeadlineExceeded, want: 0},
+		{name: "refused", err: &agent.StatusError{StatusCode: http.StatusBadRequest}, want: 0},
+		{name: "gateway timeout", err: &agent.StatusError{StatusCode: http.StatusGatewayTimeout}, want: 0},
+		{name: "server error", err: &agent.StatusError{StatusCode: http.StatusServiceUnavailable}, want: 2},
+		{name: "rate limited", err: &agent.StatusError{StatusCode: http.StatusTooManyRequests}, want: 2},
+		{name: "grpc not connected", err: fmt.Errorf("connect: %w", agent.ErrNotConnected), want: 2},
+		{name: "grpc unavailable", err: status.Error(codes.Unavailable, "reset"), want: 0},
+		{name: "grpc failed precondition", err: status.Error(codes.FailedPrecondition, "no"), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			b := agent.NewBuffer()
+			require.NoError(t, b.UpdateCounterMetric(ctx, agent.PollCount, 2))
+			transport := &stubTransport{err: tt.err}
+
+			// The last batch is sent on shutdown.
+			cancel()
+			agent.RunReporter(ctx, nil, transport, b, 1, time.Second)
+
+			assert.Equal(t, 1, transport.calls)
+			count, _ := totals([][]*metrics.Metrics{b.Snapshot()})
+			assert.Equal(t, tt.want, count)
+		})
+	}
+}
+
+// rejectingTransport applies batches except for the metrics listed in errs.
+type rejectingTransport struct {
+	errs map[string]error
+}
+
+func (t *rejectingTransport) Send(_ context.Context, batch []*metrics.Metrics) error {
+	rejected := &agent.RejectedError{Total: len(batch)}
+	for _, m := range batch {
+		if err, ok := t.errs[m.ID]; ok {
+			rejected.Rejected = append(rejected.Rejected, agent.RejectedMetric{Metric: m, Err: err})
+		}
+	}
+	if len(rejected.Rejected) == 0 {
+		return nil
+	}
+	return rejected
+}
+
+func (t *rejectingTransport) Close() error {
+	return nil
+}
+
+func TestSendKeepsOnlyUnappliedRejectedDeltas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := agent.NewBuffer()
+	require.NoError(t, b.UpdateCounterMetric(ctx, agent.PollCount, 2))
+	require.NoError(t, b.UpdateCounterMetric(ctx, "Limited", 3))
+	require.NoError(t, b.UpdateCounterMetric(ctx, "Mismatch", 5))
+	transport := &rejectingTransport{errs: map[string]error{
+		"Limited":  status.Error(codes.ResourceExhausted, "quota"),
+		"Mismatch": status.Error(codes.FailedPrecondition, "type mismatch"),
+	}}
+
+	cancel()
+	agent.RunReporter(ctx, nil, transport, b, 1, time.Second)
+
+	batch := b.Snapshot()
+	require.Len(t, batch, 1)
+	assert.Equal(t, "Limited", batch[0].ID)
+	assert.Equal(t, metrics.Counter(3), *batch[0].Delta)
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	retryBaseDelay  = 500 * time.Millisecond
+	maxBackoffShift = 32
+)
+
+// RetryPolicy allows Attempts retries after the first send. The n-th retry waits
+// BaseDelay*2^n with jitter, at most MaxDelay.
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// RetryTransport sends a batch again when it fails for a reason that may go away and
+// the server surely did not apply it, see notApplied. A batch the server may have
+// applied is never sent twice, its counters would be counted twice.
+// A wait asked for by the server, in Retry-After or gRPC RetryInfo, replaces the
+// backoff; when it is longer than MaxDelay the error is returned instead.
+type RetryTransport struct {
+	Transport
+	policy RetryPolicy
+}
+
+func NewRetryTransport(t Transport, policy RetryPolicy) *RetryTransport {
+	return &RetryTransport{
+		Transport: t,
+		policy:    policy,
+	}
+}
+
+func (t *RetryTransport) Send(ctx context.Context, batch []*metrics.Metrics) error {
+	for attempt := 0; ; attempt++ {
+		err := t.Transport.Send(ctx, batch)
+		if err == nil || attempt >= t.policy.Attempts || !notApplied(err) {
+			return err
+		}
+
+		delay := t.policy.backoff(attempt)
+		if wait := retryAfter(err); wait > 0 {
+			if t.policy.MaxDelay > 0 && wait > t.policy.MaxDelay {
+				return err
+			}
+			delay = wait
+		}
+
+		logrus.Warnf("Send failed, retry %d of %d in %s: %v", attempt+1, t.policy.Attempts, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff is the exponential delay before the retry after attempt, with the upper
+// half randomized so that agents failing together don't retry together.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if attempt < maxBackoffShift {
+		delay = p.BaseDelay << attempt
+	}
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryAfter is the wait the server asked for before the next attempt.
+func retryAfter(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.GetRetryDelay().AsDuration()
+		}
+	}
+
+	return 0
+}
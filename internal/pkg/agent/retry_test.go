@@ -0,0 +1,175 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var fastRetries = agent.RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+// flakyServer answers the first failures requests with fail and the rest with 200.
+func flakyServer(t *testing.T, failures int32, fail http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			fail(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func httpTransport(server *httptest.Server) *agent.HTTPTransport {
+	return agent.NewHTTPTransport(&config.AgentConfig{
+		ServerAddress:  strings.TrimPrefix(server.URL, "http://"),
+		RateLimit:      1,
+		RequestTimeout: 5,
+	})
+}
+
+func status503(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+func TestRetryTransport(t *testing.T) {
+	dropConnection := func(w http.ResponseWriter, _ *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}
+
+	tests := []struct {
+		name         string
+		failures     int32
+		fail         http.HandlerFunc
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "server errors", failures: 2, fail: status503, wantRequests: 3},
+		{name: "connection reset after the request", failures: 1, fail: dropConnection, wantErr: true, wantRequests: 1},
+		{
+			name:     "gateway timeout",
+			failures: 1,
+			fail: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusGatewayTimeout)
+			},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{name: "too many failures", failures: 10, fail: status503, wantErr: true, wantRequests: 4},
+		{
+			name:     "refused batch",
+			failures: 1,
+			fail: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+			},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := flakyServer(t, tt.failures, tt.fail)
+
+			err := agent.NewRetryTransport(httpTransport(server), fastRetries).Send(context.Background(), testBatch())
+
+			assert.Equal(t, tt.wantErr, err != nil, err)
+			assert.Equal(t, tt.wantRequests, requests.Load())
+		})
+	}
+}
+
+func TestRetryTransportConnectionRefused(t *testing.T) {
+	server, _ := flakyServer(t, 0, status503)
+	transport := httpTransport(server)
+	server.Close()
+
+	start := time.Now()
+	err := agent.NewRetryTransport(transport, agent.RetryPolicy{
+		Attempts:  2,
+		BaseDelay: 20 * time.Millisecond,
+		MaxDelay:  time.Second,
+	}).Send(context.Background(), testBatch())
+
+	require.Error(t, err)
+	// Two retries after at least half of 20ms and 40ms.
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+}
+
+func TestRetryTransportRetryAfter(t *testing.T) {
+	tooManyRequests := func(seconds string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Retry-After", seconds)
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}
+
+	server, requests := flakyServer(t, 1, tooManyRequests("1"))
+	start := time.Now()
+	err := agent.NewRetryTransport(httpTransport(server), agent.RetryPolicy{
+		Attempts:  1,
+		BaseDelay: time.Millisecond,
+		MaxDelay:  5 * time.Second,
+	}).Send(context.Background(), testBatch())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+	// A wait longer than the policy allows is left to the caller.
+	server, requests = flakyServer(t, 1, tooManyRequests("60"))
+	err = agent.NewRetryTransport(httpTransport(server), fastRetries).Send(context.Background(), testBatch())
+	var statusErr *agent.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusTooManyRequests, statusErr.StatusCode)
+	assert.Equal(t, time.Minute, statusErr.RetryAfter)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestRetryTransportRetryInfo(t *testing.T) {
+	st, err := status.New(codes.ResourceExhausted, "rate limit exceeded").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Minute)})
+	require.NoError(t, err)
+
+	primary := &stubTransport{err: st.Err()}
+	err = agent.NewRetryTransport(primary, fastRetries).Send(context.Background(), testBatch())
+
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 1, primary.calls)
+
+	primary = &stubTransport{err: errors.New("connection reset")}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, agent.NewRetryTransport(primary, fastRetries).Send(ctx, testBatch()))
+	assert.Equal(t, 1, primary.calls)
+}
+
+func TestRetryTransportKeepsMaybeAppliedBatches(t *testing.T) {
+	for _, code := range []codes.Code{codes.Internal, codes.Unknown, codes.Aborted} {
+		t.Run(code.String(), func(t *testing.T) {
+			primary := &stubTransport{err: status.Error(code, "failed")}
+			err := agent.NewRetryTransport(primary, fastRetries).Send(context.Background(), testBatch())
+
+			assert.Equal(t, code, status.Code(err))
+			assert.Equal(t, 1, primary.calls)
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const spoolExt = ".json"
+
+// spooledBatch is one file of the spool.
+type spooledBatch struct {
+	Created time.Time          `json:"created"`
+	Metrics []*metrics.Metrics `json:"metrics"`
+}
+
+// Spool keeps batches that could not be sent in a directory, one file per batch named
+// by its sequence number, and sends them before newer batches once the server is back.
+// While batches wait, new ones join them; otherwise batches go out concurrently.
+//
+// When the spool outgrows maxBytes its two oldest batches are merged: counter deltas
+// are summed and the newer gauge values win, which is what the server would have
+// stored after receiving both. Batches older than maxAge are dropped.
+type Spool struct {
+	Transport
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	// drainLock lets one Send at a time drain the spool, oldest batch first.
+	drainLock sync.Mutex
+
+	// lock guards the entries, it is never held over the network.
+	lock    sync.Mutex
+	entries []spoolEntry
+	size    int64
+	next    uint64
+	// sending is set while the oldest batch is on the way, it must not be merged.
+	sending bool
+}
+
+type spoolEntry struct {
+	seq  uint64
+	size int64
+}
+
+// NewSpool wraps t with a spool in dir, picking up the batches left by a previous run.
+// Zero limits disable the checks.
+func NewSpool(t Transport, dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create spool directory: %w", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool directory: %w", err)
+	}
+
+	s := &Spool{
+		Transport: t,
+		dir:       dir,
+		maxBytes:  maxBytes,
+		maxAge:    maxAge,
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".tmp") {
+			// Left behind by an interrupted write.
+			_ = os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), spoolExt), 10, 64)
+		if err != nil || !strings.HasSuffix(f.Name(), spoolExt) || f.IsDir() {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.entries = append(s.entries, spoolEntry{seq: seq, size: info.Size()})
+		s.size += info.Size()
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].seq < s.entries[j].seq })
+	if n := len(s.entries); n > 0 {
+		s.next = s.entries[n-1].seq + 1
+		logrus.Infof("Spool has %d unsent batches", n)
+	}
+
+	return s, nil
+}
+
+// Send delivers batch after the spooled ones. Batches the server surely did not apply
+// are spooled and count as delivered; the error of a batch it may have applied is
+// returned, spooling it would count its counters twice.
+func (s *Spool) Send(ctx context.Context, batch []*metrics.Metrics) error {
+	var err error
+	if s.Len() > 0 {
+		err = s.drain(ctx)
+	}
+
+	// Batches still wait when the server is down or another Send is draining them.
+	s.lock.Lock()
+	queued := len(s.entries) > 0
+	if !queued {
+		s.lock.Unlock()
+
+		if err = s.Transport.Send(ctx, batch); err == nil || !notApplied(err) {
+			return err
+		}
+		s.lock.Lock()
+	}
+	pushErr := s.push(spooledBatch{Created: time.Now(), Metrics: batch})
+	waiting := len(s.entries)
+	s.lock.Unlock()
+
+	if pushErr != nil {
+		if err == nil {
+			return fmt.Errorf("spool the batch: %w", pushErr)
+		}
+		return fmt.Errorf("%w, spool the batch: %v", err, pushErr)
+	}
+	if err != nil {
+		logrus.Warnf("Batch is spooled, %d batches wait for the server: %v", waiting, err)
+	}
+
+	return nil
+}
+
+// Len is the number of spooled batches.
+func (s *Spool) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.entries)
+}
+
+// drain sends the spooled batches oldest first and stops at the first error that
+// proves the server did not apply the batch. Other failed batches are dropped.
+// When another Send is draining already it returns at once.
+func (s *Spool) drain(ctx context.Context) error {
+	if !s.drainLock.TryLock() {
+		return nil
+	}
+
+	for {
+		s.lock.Lock()
+		if len(s.entries) == 0 {
+			// Released under lock, so that a batch queued after this check finds it free.
+			s.drainLock.Unlock()
+			s.lock.Unlock()
+			return nil
+		}
+
+		entry := s.entries[0]
+		batch, err := s.read(entry.seq)
+		if err != nil {
+			logrus.Errorf("Dropping unreadable spooled batch %d: %v", entry.seq, err)
+			s.remove(0)
+			s.lock.Unlock()
+			continue
+		}
+		if s.maxAge > 0 && time.Since(batch.Created) > s.maxAge {
+			logrus.Warnf("Dropping spooled batch %d from %s, it is too old", entry.seq, batch.Created)
+			s.remove(0)
+			s.lock.Unlock()
+			continue
+		}
+		s.sending = true
+		s.lock.Unlock()
+
+		err = s.Transport.Send(ctx, batch.Metrics)
+
+		s.lock.Lock()
+		s.sending = false
+		if err != nil && notApplied(err) {
+			s.drainLock.Unlock()
+			s.lock.Unlock()
+			return err
+		}
+		if err != nil {
+			logrus.Errorf("Dropping spooled batch %d, the server refused or may have applied it: %v", entry.seq, err)
+		}
+		s.remove(0)
+		s.lock.Unlock()
+	}
+}
+
+func (s *Spool) push(batch spooledBatch) error {
+	size, err := s.write(s.next, batch)
+	if err != nil {
+		return err
+	}
+	s.entries = append(s.entries, spoolEntry{seq: s.next, size: size})
+	s.size += size
+	s.next++
+
+	for s.maxBytes > 0 && s.size > s.maxBytes && len(s.entries) > s.oldest()+1 {
+		if err = s.mergeOldest(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// oldest is the index of the oldest batch that may be merged, the one being sent may not.
+func (s *Spool) oldest() int {
+	if s.sending {
+		return 1
+	}
+	return 0
+}
+
+// mergeOldest merges the oldest batch into the second oldest one.
+func (s *Spool) mergeOldest() error {
+	i := s.oldest()
+	first, second := s.entries[i], s.entries[i+1]
+
+	older, err := s.read(first.seq)
+	if err != nil {
+		logrus.Errorf("Dropping unreadable spooled batch %d: %v", first.seq, err)
+		s.remove(i)
+		return nil
+	}
+	newer, err := s.read(second.seq)
+	if err != nil {
+		return err
+	}
+
+	merged := spooledBatch{
+		Created: older.Created,
+		Metrics: mergeBatches(older.Metrics, newer.Metrics),
+	}
+	tmp, size, err := s.writeTemp(merged)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	// The oldest batch goes first: a crash in between loses its deltas rather than
+	// leaving them behind to be sent twice.
+	s.remove(i)
+	if err = os.Rename(tmp, s.path(second.seq)); err != nil {
+		return err
+	}
+	s.size += size - second.size
+	s.entries[i].size = size
+
+	return nil
+}
+
+// mergeBatches combines two batches into one with the effect of sending both in order.
+func mergeBatches(older, newer []*metrics.Metrics) []*metrics.Metrics {
+	merged := make([]*metrics.Metrics, 0, len(older)+len(newer))
+	index := make(map[string]int, len(older)+len(newer))
+
+	for _, batch := range [][]*metrics.Metrics{older, newer} {
+		for _, m := range batch {
+			key := m.MType + "/" + m.ID
+			i, ok := index[key]
+			if !ok {
+				m := *m
+				index[key] = len(merged)
+				merged = append(merged, &m)
+				continue
+			}
+
+			if m.MType == metrics.CounterMetricName && merged[i].Delta != nil && m.Delta != nil {
+				delta := *merged[i].Delta + *m.Delta
+				merged[i].Delta = &delta
+			} else {
+				m := *m
+				merged[i] = &m
+			}
+		}
+	}
+
+	return merged
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolExt))
+}
+
+func (s *Spool) read(seq uint64) (spooledBatch, error) {
+	var batch spooledBatch
+
+	data, err := os.ReadFile(s.path(seq))
+	if err != nil {
+		return batch, err
+	}
+	err = json.Unmarshal(data, &batch)
+
+	return batch, err
+}
+
+// write replaces the batch file atomically, a crash leaves either version behind.
+func (s *Spool) write(seq uint64, batch spooledBatch) (int64, error) {
+	tmp, size, err := s.writeTemp(batch)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	if err = os.Rename(tmp, s.path(seq)); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func (s *Spool) writeTemp(batch spooledBatch) (string, int64, error) {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return "", 0, err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "batch-*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", 0, err
+	}
+
+	return tmp.Name(), int64(len(data)), nil
+}
+
+// remove forgets the i-th oldest batch.
+func (s *Spool) remove(i int) {
+	entry := s.entries[i]
+	if err := os.Remove(s.path(entry.seq)); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("Cannot remove spooled batch %d: %v", entry.seq, err)
+	}
+	s.entries = append(s.entries[:i], s.entries[i+1:]...)
+	s.size -= entry.size
+}
@@ -0,0 +1,177 @@
+package agent_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTransport keeps the batches it delivers while err is nil.
+type recordingTransport struct {
+	err     error
+	batches [][]*metrics.Metrics
+}
+
+func (t *recordingTransport) Send(_ context.Context, batch []*metrics.Metrics) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.batches = append(t.batches, batch)
+	return nil
+}
+
+func (t *recordingTransport) Close() error {
+	return nil
+}
+
+func pollBatch(delta int64, alloc float64) []*metrics.Metrics {
+	d := metrics.Counter(delta)
+	v := metrics.Gauge(alloc)
+	return []*metrics.Metrics{
+		{ID: "PollCount", MType: metrics.CounterMetricName, Delta: &d},
+		{ID: "Alloc", MType: metrics.GaugeMetricName, Value: &v},
+	}
+}
+
+// totals applies batches the way the server does.
+func totals(batches [][]*metrics.Metrics) (metrics.Counter, metrics.Gauge) {
+	var (
+		count metrics.Counter
+		alloc metrics.Gauge
+	)
+	for _, batch := range batches {
+		for _, m := range batch {
+			if m.Delta != nil {
+				count += *m.Delta
+			}
+			if m.Value != nil {
+				alloc = *m.Value
+			}
+		}
+	}
+	return count, alloc
+}
+
+func TestSpool(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	transport := &recordingTransport{err: &agent.StatusError{StatusCode: http.StatusServiceUnavailable}}
+
+	spool, err := agent.NewSpool(transport, dir, 0, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, spool.Send(ctx, pollBatch(1, 10)))
+	require.NoError(t, spool.Send(ctx, pollBatch(2, 20)))
+	assert.Equal(t, 2, spool.Len())
+
+	// Spooled batches survive a restart.
+	spool, err = agent.NewSpool(transport, dir, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, spool.Len())
+
+	transport.err = nil
+	require.NoError(t, spool.Send(ctx, pollBatch(3, 30)))
+	assert.Equal(t, 0, spool.Len())
+	require.Len(t, transport.batches, 3)
+	assert.Equal(t, pollBatch(1, 10), transport.batches[0])
+	assert.Equal(t, pollBatch(2, 20), transport.batches[1])
+	assert.Equal(t, pollBatch(3, 30), transport.batches[2])
+
+	// Refused batches are not spooled.
+	transport.err = &agent.StatusError{StatusCode: http.StatusBadRequest}
+	assert.Error(t, spool.Send(ctx, pollBatch(1, 40)))
+	assert.Equal(t, 0, spool.Len())
+}
+
+func TestSpoolDropsMaybeAppliedBatches(t *testing.T) {
+	ctx := context.Background()
+	transport := &recordingTransport{err: &agent.StatusError{StatusCode: http.StatusGatewayTimeout}}
+
+	spool, err := agent.NewSpool(transport, t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	// The server may have applied the batch, spooling it would count it twice.
+	assert.Error(t, spool.Send(ctx, pollBatch(1, 10)))
+	assert.Equal(t, 0, spool.Len())
+
+	transport.err = &agent.StatusError{StatusCode: http.StatusServiceUnavailable}
+	require.NoError(t, spool.Send(ctx, pollBatch(2, 20)))
+	assert.Equal(t, 1, spool.Len())
+
+	// A spooled batch that may have been applied is not kept either.
+	transport.err = &agent.StatusError{StatusCode: http.StatusGatewayTimeout}
+	assert.Error(t, spool.Send(ctx, pollBatch(3, 30)))
+	assert.Equal(t, 0, spool.Len())
+}
+
+func TestSpoolMergesOverLimit(t *testing.T) {
+	ctx := context.Background()
+	transport := &recordingTransport{err: &agent.StatusError{StatusCode: http.StatusBadGateway}}
+
+	spool, err := agent.NewSpool(transport, t.TempDir(), 400, 0)
+	require.NoError(t, err)
+
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, spool.Send(ctx, pollBatch(int64(i), float64(i))))
+	}
+	assert.Less(t, spool.Len(), 10)
+
+	transport.err = nil
+	require.NoError(t, spool.Send(ctx, pollBatch(0, 11)))
+
+	count, alloc := totals(transport.batches)
+	assert.Equal(t, metrics.Counter(55), count)
+	assert.Equal(t, metrics.Gauge(11), alloc)
+}
+
+func TestSpoolDropsOldBatches(t *testing.T) {
+	ctx := context.Background()
+	transport := &recordingTransport{err: &agent.StatusError{StatusCode: http.StatusServiceUnavailable}}
+
+	spool, err := agent.NewSpool(transport, t.TempDir(), 0, time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, spool.Send(ctx, pollBatch(1, 10)))
+
+	time.Sleep(5 * time.Millisecond)
+	transport.err = nil
+	require.NoError(t, spool.Send(ctx, pollBatch(2, 20)))
+
+	assert.Equal(t, [][]*metrics.Metrics{pollBatch(2, 20)}, transport.batches)
+}
+
+func TestSpoolSendsConcurrently(t *testing.T) {
+	const senders = 3
+	ctx := context.Background()
+	transport := &blockingTransport{release: make(chan struct{})}
+
+	spool, err := agent.NewSpool(transport, t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, spool.Send(ctx, pollBatch(1, 1)))
+		}()
+	}
+
+	// Every send reaches the server while the others still wait for it.
+	assert.Eventually(t, func() bool {
+		transport.lock.Lock()
+		defer transport.lock.Unlock()
+		return transport.inFlight == senders
+	}, time.Second, time.Millisecond)
+	close(transport.release)
+	wg.Wait()
+
+	assert.Equal(t, metrics.Counter(senders), transport.sent)
+	assert.Zero(t, spool.Len())
+}
@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrResponseSignature means the server's response could not be verified. The batch
+// may have been applied, so it is not sent again.
+var ErrResponseSignature = errors.New("invalid response signature")
+
+// ErrNotConnected means a gRPC call was not made because the connection to the server
+// could not be established, so the server has not seen the batch.
+var ErrNotConnected = errors.New("gRPC server is not connected")
+
+// StatusError is a batch refused by the HTTP server. RetryAfter is the wait asked for
+// in the Retry-After header.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status code: %v and not 200", e.StatusCode)
+}
+
+// RejectedError lists the metrics of a batch the server refused one by one, the rest
+// of the batch was applied.
+type RejectedError struct {
+	Rejected []RejectedMetric
+	Total    int
+}
+
+type RejectedMetric struct {
+	Metric *metrics.Metrics
+	Err    error
+}
+
+func (e *RejectedError) Error() string {
+	first := e.Rejected[0]
+	return fmt.Sprintf("%d of %d metrics rejected, first %s: %v", len(e.Rejected), e.Total, first.Metric.ID, first.Err)
+}
+
+// Transport delivers metric batches to the server.
+type Transport interface {
+	Send(ctx context.Context, batch []*metrics.Metrics) error
+	Close() error
+}
+
+// NewTransport creates the transport selected by c.Transport. The gRPC transport falls
+// back to HTTP whenever the gRPC server can't be reached. Failed sends are retried as
+// configured.
+func NewTransport(c *config.AgentConfig) (Transport, error) {
+	var t Transport
+	switch c.Transport {
+	case config.TransportHTTP:
+		t = NewHTTPTransport(c)
+	case config.TransportGRPC:
+		g, err := NewGRPCTransport(c)
+		if err != nil {
+			return nil, err
+		}
+		t = NewFallbackTransport(g, NewHTTPTransport(c))
+	default:
+		return nil, fmt.Errorf("unknown transport %q", c.Transport)
+	}
+
+	if c.RetryAttempts > 0 {
+		t = NewRetryTransport(t, RetryPolicy{
+			Attempts:  c.RetryAttempts,
+			BaseDelay: retryBaseDelay,
+			MaxDelay:  time.Duration(c.RetryMaxDelay) * time.Second,
+		})
+	}
+
+	return t, nil
+}
+
+// HTTPTransport posts batches as JSON to the /updates/ endpoint.
+type HTTPTransport struct {
+	c      *config.AgentConfig
+	client *http.Client
+}
+
+func NewHTTPTransport(c *config.AgentConfig) *HTTPTransport {
+	return &HTTPTransport{
+		c:      c,
+		client: newHTTPClient(c),
+	}
+}
+
+func (t *HTTPTransport) Send(ctx context.Context, batch []*metrics.Metrics) error {
+	return sendBatchJSON(ctx, t.client, t.c.URL("/updates/"), batch, t.c)
+}
+
+func (t *HTTPTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+// FallbackTransport sends batches with the primary transport and retries them with
+// the fallback one when the primary could not connect or does not know the call.
+// Other errors are returned as is: the server may have applied part of the batch and
+// sending it again would count the counters twice.
+type FallbackTransport struct {
+	primary  Transport
+	fallback Transport
+}
+
+func NewFallbackTransport(primary, fallback Transport) *FallbackTransport {
+	return &FallbackTransport{
+		primary:  primary,
+		fallback: fallback,
+	}
+}
+
+func (t *FallbackTransport) Send(ctx context.Context, batch []*metrics.Metrics) error {
+	err := t.primary.Send(ctx, batch)
+	if err == nil || !unavailable(err) {
+		return err
+	}
+
+	logrus.Warnf("Primary transport is unavailable, falling back: %v", err)
+	return t.fallback.Send(ctx, batch)
+}
+
+func (t *FallbackTransport) Close() error {
+	return errors.Join(t.primary.Close(), t.fallback.Close())
+}
+
+// unavailable reports whether err means the request never reached the server's
+// handler. An Unavailable status is not enough, the connection may have broken after
+// the server handled the call.
+func unavailable(err error) bool {
+	return errors.Is(err, ErrNotConnected) || status.Code(err) == codes.Unimplemented
+}
+
+// notApplied reports whether err proves that the server did not apply the batch, so
+// that its counter deltas can go with the next batch without being counted twice.
+func notApplied(err error) bool {
+	if errors.Is(err, ErrResponseSignature) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode
+		return (code >= http.StatusInternalServerError && code != http.StatusGatewayTimeout) ||
+			code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+
+	// The connection was never made.
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) || errors.Is(err, ErrNotConnected) {
+		return true
+	}
+
+	switch status.Code(err) {
+	case codes.Unimplemented, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
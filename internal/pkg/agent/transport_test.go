@@ -0,0 +1,167 @@
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pbv2 "github.com/mayr0y/animated-octo-couscous.git/api/serverv2"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/agent/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	metricsgrpc "github.com/mayr0y/animated-octo-couscous.git/internal/pkg/server/grpc"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func testBatch() []*metrics.Metrics {
+	delta := metrics.Counter(3)
+	value := metrics.Gauge(1.5)
+	return []*metrics.Metrics{
+		{ID: "PollCount", MType: metrics.CounterMetricName, Delta: &delta},
+		{ID: "Alloc", MType: metrics.GaugeMetricName, Value: &value},
+	}
+}
+
+func TestGRPCTransport(t *testing.T) {
+	key := []byte("secret")
+	store := storage.NewMetrics()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(metricsgrpc.SignUnaryInterceptor(key, sign.NewReplayGuard(time.Minute, 100))))
+	pbv2.RegisterMetricsServer(server, metricsgrpc.NewServerV2(store, nil))
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	c := &config.AgentConfig{GRPCAddress: "localhost:3200", SignKeyByte: key}
+	transport, err := agent.NewGRPCTransport(c, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	require.NoError(t, err)
+	defer transport.Close()
+
+	require.NoError(t, transport.Send(context.Background(), testBatch()))
+
+	counter, ok := store.GetMetric(context.Background(), "PollCount", metrics.CounterMetricName)
+	require.True(t, ok)
+	assert.Equal(t, metrics.Counter(3), *counter.Delta)
+	gauge, ok := store.GetMetric(context.Background(), "Alloc", metrics.GaugeMetricName)
+	require.True(t, ok)
+	assert.Equal(t, metrics.Gauge(1.5), *gauge.Value)
+
+	c.SignKeyByte = []byte("other")
+	assert.Equal(t, codes.Unauthenticated, status.Code(transport.Send(context.Background(), testBatch())))
+}
+
+func TestTransportFallsBackToHTTP(t *testing.T) {
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer server.Close()
+
+	// A port nobody listens on.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcAddress := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	c := &config.AgentConfig{
+		ServerAddress: strings.TrimPrefix(server.URL, "http://"),
+		GRPCAddress:   grpcAddress,
+		Transport:     config.TransportGRPC,
+	}
+	transport, err := agent.NewTransport(c)
+	require.NoError(t, err)
+	defer transport.Close()
+
+	require.NoError(t, transport.Send(context.Background(), testBatch()))
+	assert.Equal(t, 1, received)
+}
+
+type stubTransport struct {
+	err   error
+	calls int
+}
+
+func (s *stubTransport) Send(context.Context, []*metrics.Metrics) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubTransport) Close() error {
+	return nil
+}
+
+func TestFallbackTransport(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantFallback  int
+		wantErrorCode codes.Code
+	}{
+		{name: "sent", wantErrorCode: codes.OK},
+		{name: "not connected", err: fmt.Errorf("connect: %w", agent.ErrNotConnected), wantFallback: 1, wantErrorCode: codes.OK},
+		{name: "unimplemented", err: status.Error(codes.Unimplemented, "v1"), wantFallback: 1, wantErrorCode: codes.OK},
+		{name: "unavailable after the call", err: status.Error(codes.Unavailable, "reset"), wantErrorCode: codes.Unavailable},
+		{name: "rejected", err: status.Error(codes.PermissionDenied, "no"), wantErrorCode: codes.PermissionDenied},
+		{name: "timed out", err: status.Error(codes.DeadlineExceeded, "slow"), wantErrorCode: codes.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &stubTransport{err: tt.err}
+			fallback := &stubTransport{}
+
+			err := agent.NewFallbackTransport(primary, fallback).Send(context.Background(), testBatch())
+
+			assert.Equal(t, tt.wantErrorCode, status.Code(err))
+			assert.Equal(t, 1, primary.calls)
+			assert.Equal(t, tt.wantFallback, fallback.calls)
+		})
+	}
+}
+
+func TestGRPCTransportRejectedMetrics(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMetrics()
+	require.NoError(t, store.UpdateGaugeMetric(ctx, "Alloc", 1))
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pbv2.RegisterMetricsServer(server, metricsgrpc.NewServerV2(store, nil))
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	c := &config.AgentConfig{GRPCAddress: "localhost:3200"}
+	transport, err := agent.NewGRPCTransport(c, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	require.NoError(t, err)
+	defer transport.Close()
+
+	// Alloc is a gauge on the server, the counter is refused and PollCount is stored.
+	delta := metrics.Counter(1)
+	batch := append(testBatch()[:1], &metrics.Metrics{ID: "Alloc", MType: metrics.CounterMetricName, Delta: &delta})
+	err = transport.Send(ctx, batch)
+
+	var rejected *agent.RejectedError
+	require.ErrorAs(t, err, &rejected)
+	require.Len(t, rejected.Rejected, 1)
+	assert.Same(t, batch[1], rejected.Rejected[0].Metric)
+	assert.Equal(t, 2, rejected.Total)
+
+	counter, ok := store.GetMetric(ctx, "PollCount", metrics.CounterMetricName)
+	require.True(t, ok)
+	assert.Equal(t, metrics.Counter(3), *counter.Delta)
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+)
+
+// Authenticator resolves bearer secrets to tokens. The optional admin secret from the
+// configuration is always accepted, so that the first tokens can be issued.
+type Authenticator struct {
+	store     TokenStore
+	adminHash string
+}
+
+func NewAuthenticator(store TokenStore, adminSecret string) *Authenticator {
+	a := &Authenticator{store: store}
+	if adminSecret != "" {
+		a.adminHash = HashSecret(adminSecret)
+	}
+
+	return a
+}
+
+func (a *Authenticator) Authenticate(ctx context.Context, secret string) (*Token, error) {
+	if secret == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	hash := HashSecret(secret)
+	if a.adminHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(a.adminHash)) == 1 {
+		return &Token{ID: "admin", Name: "admin", Scopes: []Scope{ScopeAdmin}}, nil
+	}
+
+	if a.store == nil {
+		return nil, ErrUnauthenticated
+	}
+
+	t, err := a.store.Lookup(ctx, hash)
+	if errors.Is(err, ErrTokenNotFound) {
+		return nil, ErrUnauthenticated
+	}
+
+	return t, err
+}
+
+// Authorize authenticates the secret and checks that its token has the scope.
+func (a *Authenticator) Authorize(ctx context.Context, secret string, scope Scope) (*Token, error) {
+	t, err := a.Authenticate(ctx, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !t.Allows(scope) {
+		return nil, ErrForbidden
+	}
+
+	return t, nil
+}
+
+func (a *Authenticator) Store() TokenStore {
+	return a.store
+}
+
+// BearerToken extracts the secret of an "Authorization: Bearer" value.
+func BearerToken(header string) string {
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(header[len(prefix):])
+}
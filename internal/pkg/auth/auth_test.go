@@ -0,0 +1,134 @@
+package auth_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"id": "bootstrap", "name": "ops", "token": "plain-secret", "scopes": ["admin"]}
+	]`), 0600))
+
+	store, err := auth.NewFileStore(path)
+	require.NoError(t, err)
+
+	a := auth.NewAuthenticator(store, "")
+	token, err := a.Authorize(context.Background(), "plain-secret", auth.ScopeWrite)
+	require.NoError(t, err)
+	assert.Equal(t, "ops", token.Name)
+
+	secret, issued, err := auth.Issue(context.Background(), store, "agent-1", []auth.Scope{auth.ScopeWrite}, []string{"agent1."})
+	require.NoError(t, err)
+
+	// Issued tokens survive a restart, and no secret is written to disk.
+	reloaded, err := auth.NewFileStore(path)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), secret)
+	assert.NotContains(t, string(data), "plain-secret")
+
+	token, err = auth.NewAuthenticator(reloaded, "").Authorize(context.Background(), secret, auth.ScopeWrite)
+	require.NoError(t, err)
+	assert.Equal(t, issued.ID, token.ID)
+	assert.Equal(t, []string{"agent1."}, token.Prefixes)
+
+	_, err = a.Authorize(context.Background(), secret, auth.ScopeRead)
+	assert.ErrorIs(t, err, auth.ErrForbidden)
+	_, err = a.Authorize(context.Background(), "unknown", auth.ScopeRead)
+	assert.ErrorIs(t, err, auth.ErrUnauthenticated)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"id": "x", "token": "s", "scopes": ["root"]}]`), 0600))
+	_, err = auth.NewFileStore(path)
+	assert.Error(t, err)
+}
+
+func TestAdminToken(t *testing.T) {
+	a := auth.NewAuthenticator(nil, "bootstrap")
+
+	token, err := a.Authorize(context.Background(), "bootstrap", auth.ScopeAdmin)
+	require.NoError(t, err)
+	assert.True(t, token.Allows(auth.ScopeRead))
+
+	_, err = a.Authorize(context.Background(), "", auth.ScopeRead)
+	assert.ErrorIs(t, err, auth.ErrUnauthenticated)
+}
+
+func TestStorePrefixes(t *testing.T) {
+	s := auth.NewStore(storage.NewMetrics())
+	ctx := auth.WithToken(context.Background(), &auth.Token{
+		Scopes:   []auth.Scope{auth.ScopeWrite},
+		Prefixes: []string{"app."},
+	})
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, "app.Alloc", 1))
+	assert.ErrorIs(t, s.UpdateGaugeMetric(ctx, "Alloc", 1), auth.ErrForbidden)
+	assert.ErrorIs(t, s.UpdateMetrics(ctx, []*metrics.Metrics{
+		{ID: "app.PollCount", MType: metrics.CounterMetricName, Delta: new(metrics.Counter)},
+		{ID: "PollCount", MType: metrics.CounterMetricName, Delta: new(metrics.Counter)},
+	}), auth.ErrForbidden)
+
+	// Requests without a token, e.g. internal ones, are not restricted.
+	require.NoError(t, s.UpdateGaugeMetric(context.Background(), "Alloc", 2))
+
+	_, ok := s.GetMetric(ctx, "Alloc", metrics.GaugeMetricName)
+	assert.False(t, ok)
+	all, err := s.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+	assert.Contains(t, all, "app.Alloc")
+}
+
+func TestDBStore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS tokens").WillReturnResult(sqlmock.NewResult(0, 0))
+	store, err := auth.NewDBStore(db)
+	require.NoError(t, err)
+
+	prefixes := []string{"agent1.", "a,b"}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO tokens")).
+		WithArgs(sqlmock.AnyArg(), "agent-1", sqlmock.AnyArg(), "write,read", `["agent1.","a,b"]`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	secret, _, err := auth.Issue(context.Background(), store, "agent-1", []auth.Scope{auth.ScopeWrite, auth.ScopeRead}, prefixes)
+	require.NoError(t, err)
+
+	created := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT token_id, name, scopes, prefixes, created_at FROM tokens")).
+		WithArgs(auth.HashSecret(secret)).
+		WillReturnRows(sqlmock.NewRows([]string{"token_id", "name", "scopes", "prefixes", "created_at"}).
+			AddRow("id", "agent-1", "write,read", `["agent1.","a,b"]`, created))
+	token, err := store.Lookup(context.Background(), auth.HashSecret(secret))
+	require.NoError(t, err)
+	assert.Equal(t, []auth.Scope{auth.ScopeWrite, auth.ScopeRead}, token.Scopes)
+	assert.Equal(t, prefixes, token.Prefixes)
+
+	// Tokens stored before the JSON encoding keep working.
+	mock.ExpectQuery("SELECT").
+		WillReturnRows(sqlmock.NewRows([]string{"token_id", "name", "scopes", "prefixes", "created_at"}).
+			AddRow("old", "agent-0", "write", "agent0.,shared.", created))
+	token, err = store.Lookup(context.Background(), "old-hash")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"agent0.", "shared."}, token.Prefixes)
+
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"token_id"}))
+	_, err = store.Lookup(context.Background(), "missing")
+	assert.ErrorIs(t, err, auth.ErrTokenNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type DBStore struct {
+	connection *sql.DB
+}
+
+func NewDBStore(db *sql.DB) (*DBStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS tokens(
+    						token_id VARCHAR (32) PRIMARY KEY,
+    						name VARCHAR (100) NOT NULL,
+    						hash CHAR (64) NOT NULL UNIQUE,
+    						scopes TEXT NOT NULL,
+    						prefixes TEXT NOT NULL,
+    						created_at TIMESTAMPTZ NOT NULL);`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DBStore{connection: db}, nil
+}
+
+func (db *DBStore) Lookup(ctx context.Context, hash string) (*Token, error) {
+	row := db.connection.QueryRowContext(ctx,
+		`SELECT token_id, name, scopes, prefixes, created_at FROM tokens WHERE hash = $1`, hash)
+
+	t := &Token{Hash: hash}
+	var scopes, prefixes string
+	err := row.Scan(&t.ID, &t.Name, &scopes, &prefixes, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range splitList(scopes) {
+		t.Scopes = append(t.Scopes, Scope(s))
+	}
+	if t.Prefixes, err = decodePrefixes(prefixes); err != nil {
+		return nil, fmt.Errorf("prefixes of token %s: %w", t.ID, err)
+	}
+
+	return t, nil
+}
+
+func (db *DBStore) Create(ctx context.Context, t *Token) error {
+	scopes := make([]string, 0, len(t.Scopes))
+	for _, s := range t.Scopes {
+		scopes = append(scopes, string(s))
+	}
+
+	// Prefixes are free text and may contain commas, they are kept as a JSON array,
+	// [] rather than null when there are none.
+	prefixes, err := json.Marshal(append([]string{}, t.Prefixes...))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.connection.ExecContext(ctx,
+		`INSERT INTO tokens (token_id, name, hash, scopes, prefixes, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		t.ID, t.Name, t.Hash, strings.Join(scopes, ","), string(prefixes), t.CreatedAt)
+
+	return err
+}
+
+func (db *DBStore) Close() error {
+	return db.connection.Close()
+}
+
+// decodePrefixes reads the JSON array of prefixes. Tokens created before prefixes were
+// stored as JSON hold them separated by commas.
+func decodePrefixes(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "[") {
+		return splitList(s), nil
+	}
+
+	var prefixes []string
+	if err := json.Unmarshal([]byte(s), &prefixes); err != nil {
+		return nil, err
+	}
+	if len(prefixes) == 0 {
+		return nil, nil
+	}
+
+	return prefixes, nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}
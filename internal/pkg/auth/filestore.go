@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileStore keeps tokens in a JSON file. Entries written by hand may carry the
+// plaintext secret in "token" instead of "hash"; it is hashed on load.
+type FileStore struct {
+	path string
+
+	lock   sync.RWMutex
+	tokens []*Token
+	byHash map[string]*Token
+}
+
+type fileEntry struct {
+	Token
+	Secret string `json:"token,omitempty"`
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:   path,
+		byHash: make(map[string]*Token),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fileEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse tokens file: %w", err)
+	}
+
+	for i := range entries {
+		t := entries[i].Token
+		if entries[i].Secret != "" {
+			t.Hash = HashSecret(entries[i].Secret)
+		}
+		if t.Hash == "" {
+			return nil, fmt.Errorf("token %q has neither hash nor token", t.ID)
+		}
+		for _, scope := range t.Scopes {
+			if _, err = ParseScope(string(scope)); err != nil {
+				return nil, fmt.Errorf("token %q: %w", t.ID, err)
+			}
+		}
+		s.add(&t)
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) Lookup(_ context.Context, hash string) (*Token, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	t, ok := s.byHash[hash]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+
+	return t, nil
+}
+
+func (s *FileStore) Create(_ context.Context, t *Token) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.byHash[t.Hash]; ok {
+		return errors.New("token already exists")
+	}
+
+	tokens := append(s.tokens[:len(s.tokens):len(s.tokens)], t)
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	s.add(t)
+
+	return nil
+}
+
+func (s *FileStore) add(t *Token) {
+	s.tokens = append(s.tokens, t)
+	s.byHash[t.Hash] = t
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+)
+
+// Store enforces the metric name prefixes of the token in the request context.
+// Metrics outside the prefixes look absent to readers and are rejected with
+// ErrForbidden for writers.
+type Store struct {
+	storage.Store
+}
+
+func NewStore(s storage.Store) *Store {
+	return &Store{Store: s}
+}
+
+func (s *Store) UpdateCounterMetric(ctx context.Context, name string, value metrics.Counter) error {
+	if err := checkMetric(ctx, name); err != nil {
+		return err
+	}
+
+	return s.Store.UpdateCounterMetric(ctx, name, value)
+}
+
+func (s *Store) UpdateGaugeMetric(ctx context.Context, name string, value metrics.Gauge) error {
+	if err := checkMetric(ctx, name); err != nil {
+		return err
+	}
+
+	return s.Store.UpdateGaugeMetric(ctx, name, value)
+}
+
+func (s *Store) UpdateMetrics(ctx context.Context, metricBatch []*metrics.Metrics) error {
+	for _, m := range metricBatch {
+		if err := checkMetric(ctx, m.ID); err != nil {
+			return err
+		}
+	}
+
+	return s.Store.UpdateMetrics(ctx, metricBatch)
+}
+
+func (s *Store) ResetCounterMetric(ctx context.Context, name string) error {
+	if err := checkMetric(ctx, name); err != nil {
+		return err
+	}
+
+	return s.Store.ResetCounterMetric(ctx, name)
+}
+
+func (s *Store) GetMetric(ctx context.Context, name string, metricType string) (*metrics.Metrics, bool) {
+	if !AllowsMetric(ctx, name) {
+		return nil, false
+	}
+
+	return s.Store.GetMetric(ctx, name, metricType)
+}
+
+func (s *Store) GetMetrics(ctx context.Context) (map[string]*metrics.Metrics, error) {
+	all, err := s.Store.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t, ok := FromContext(ctx)
+	if !ok || len(t.Prefixes) == 0 {
+		return all, nil
+	}
+
+	allowed := make(map[string]*metrics.Metrics)
+	for k, m := range all {
+		if t.AllowsMetric(m.ID) {
+			allowed[k] = m
+		}
+	}
+
+	return allowed, nil
+}
+
+func checkMetric(ctx context.Context, name string) error {
+	if !AllowsMetric(ctx, name) {
+		return fmt.Errorf("%w: metric %s", ErrForbidden, name)
+	}
+
+	return nil
+}
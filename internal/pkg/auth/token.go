@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	// ScopeAdmin implies read and write and allows issuing tokens.
+	ScopeAdmin Scope = "admin"
+
+	secretPrefix = "mt_"
+	secretSize   = 32
+)
+
+var (
+	ErrUnauthenticated = errors.New("missing or invalid token")
+	ErrForbidden       = errors.New("token is not allowed to access this resource")
+	ErrTokenNotFound   = errors.New("token not found")
+)
+
+// Token grants its holder the scopes on metrics whose names start with one of the
+// prefixes, or on all metrics when there are none. Only the hash of the secret is kept.
+type Token struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []Scope   `json:"scopes"`
+	Prefixes  []string  `json:"prefixes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TokenStore interface {
+	Lookup(ctx context.Context, hash string) (*Token, error)
+	Create(ctx context.Context, t *Token) error
+}
+
+func (t *Token) Allows(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Token) AllowsMetric(name string) bool {
+	if len(t.Prefixes) == 0 {
+		return true
+	}
+
+	for _, p := range t.Prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(s); scope {
+	case ScopeRead, ScopeWrite, ScopeAdmin:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown scope %q", s)
+	}
+}
+
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue creates a token in the store and returns its secret, which is not stored anywhere.
+func Issue(ctx context.Context, store TokenStore, name string, scopes []Scope, prefixes []string) (string, *Token, error) {
+	if len(scopes) == 0 {
+		return "", nil, errors.New("at least one scope is required")
+	}
+
+	raw := make([]byte, secretSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	secret := secretPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	t := &Token{
+		ID:        hex.EncodeToString(raw[:8]),
+		Name:      name,
+		Hash:      HashSecret(secret),
+		Scopes:    scopes,
+		Prefixes:  prefixes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := store.Create(ctx, t); err != nil {
+		return "", nil, err
+	}
+
+	return secret, t, nil
+}
+
+type tokenKey struct{}
+
+func WithToken(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+func FromContext(ctx context.Context) (*Token, bool) {
+	t, ok := ctx.Value(tokenKey{}).(*Token)
+	return t, ok
+}
+
+// AllowsMetric reports whether the token of ctx may access the metric. Requests without
+// a token were not subject to authentication and are allowed.
+func AllowsMetric(ctx context.Context, name string) bool {
+	t, ok := FromContext(ctx)
+	return !ok || t.AllowsMetric(name)
+}
@@ -0,0 +1,187 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/certs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue writes a leaf certificate and its key signed by the CA and returns their paths.
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o600))
+
+	serverCert, serverKey := ca.issue(t, dir, "server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "agent-1", 3, x509.ExtKeyUsageClientAuth)
+
+	serverCerts, err := certs.NewReloader(serverCert, serverKey, caFile)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, certs.RequestIdentity(r))
+	}))
+	server.TLS = serverCerts.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(cfg *certs.Reloader) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg.ClientConfig(server.Listener.Addr().String())}}
+		defer client.CloseIdleConnections()
+
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	clientCerts, err := certs.NewReloader(clientCert, clientKey, caFile)
+	require.NoError(t, err)
+	identity, err := get(clientCerts)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", identity)
+
+	anonymous, err := certs.NewReloader("", "", caFile)
+	require.NoError(t, err)
+	_, err = get(anonymous)
+	assert.Error(t, err)
+
+	// Replace the client certificate on disk and pick it up without a new reloader.
+	rotatedCert, rotatedKey := ca.issue(t, dir, "agent-2", 4, x509.ExtKeyUsageClientAuth)
+	require.NoError(t, os.Rename(rotatedCert, clientCert))
+	require.NoError(t, os.Rename(rotatedKey, clientKey))
+	require.NoError(t, clientCerts.Reload())
+	identity, err = get(clientCerts)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-2", identity)
+}
+
+func TestClientConfigFollowsCAReload(t *testing.T) {
+	dir := t.TempDir()
+	oldCA, newCA := newTestCA(t), newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, oldCA.pem, 0o600))
+
+	serverCert, serverKey := newCA.issue(t, dir, "server", 2, x509.ExtKeyUsageServerAuth)
+	serverCerts, err := certs.NewReloader(serverCert, serverKey, "")
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = serverCerts.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	clientCerts, err := certs.NewReloader("", "", caFile)
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCerts.ClientConfig(server.Listener.Addr().String())}}
+	get := func() error {
+		defer client.CloseIdleConnections()
+
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	assert.Error(t, get())
+
+	// The transport built before the reload trusts the new CA.
+	require.NoError(t, os.WriteFile(caFile, newCA.pem, 0o600))
+	require.NoError(t, clientCerts.Reload())
+	assert.NoError(t, get())
+
+	// The certificate has to be issued for the dialed host.
+	client.Transport = &http.Transport{TLSClientConfig: clientCerts.ClientConfig("metrics.example.com:443")}
+	assert.Error(t, get())
+}
+
+func TestNewReloader(t *testing.T) {
+	_, err := certs.NewReloader("server.crt", "", "")
+	assert.Error(t, err)
+
+	_, err = certs.NewReloader("", "", filepath.Join(t.TempDir(), "missing.crt"))
+	assert.Error(t, err)
+}
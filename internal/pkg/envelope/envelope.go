@@ -0,0 +1,181 @@
+// Package envelope implements hybrid encryption of request bodies: the payload is
+// sealed with a random AES-256-GCM data key, and the data key is wrapped with RSA-OAEP.
+//
+// Layout (version 2):
+//
+//	magic "MENV" | version (1 byte) | key ID length (1 byte) | key ID |
+//	wrapped key length (uint16, big endian) | wrapped key | nonce (12 bytes) |
+//	ciphertext with GCM tag
+//
+// Version 1 has no key ID fields. Everything before the nonce is authenticated as
+// additional data.
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	Version1 = 1
+	Version2 = 2
+
+	dataKeySize = 32
+	nonceSize   = 12
+)
+
+var (
+	magic = []byte("MENV")
+
+	ErrInvalidEnvelope    = errors.New("invalid envelope")
+	ErrUnsupportedVersion = errors.New("unsupported envelope version")
+)
+
+type parsed struct {
+	header     []byte
+	kid        string
+	wrappedKey []byte
+	nonce      []byte
+	ciphertext []byte
+}
+
+// IsEnvelope reports whether data starts with the envelope magic.
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Seal encrypts plaintext for key. kid names the key so that the receiver can pick it
+// out of several; it may be empty and must not exceed 255 bytes.
+func Seal(key *rsa.PublicKey, kid string, plaintext []byte) ([]byte, error) {
+	if len(kid) > 255 {
+		return nil, fmt.Errorf("key id is too long: %d bytes", len(kid))
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, dataKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(magic)+4+len(kid)+len(wrappedKey))
+	header = append(header, magic...)
+	header = append(header, Version2, byte(len(kid)))
+	header = append(header, kid...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrappedKey)))
+	header = append(header, wrappedKey...)
+
+	out := make([]byte, 0, len(header)+nonceSize+len(plaintext)+aead.Overhead())
+	out = append(out, header...)
+	out = append(out, nonce...)
+
+	return aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// KeyID returns the key ID of an envelope, empty for version 1.
+func KeyID(data []byte) (string, error) {
+	p, err := parse(data)
+	if err != nil {
+		return "", err
+	}
+
+	return p.kid, nil
+}
+
+func Open(key *rsa.PrivateKey, data []byte) ([]byte, error) {
+	p, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, p.wrappedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unwrap data key: %v", ErrInvalidEnvelope, err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, p.nonce, p.ciphertext, p.header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+
+	return plaintext, nil
+}
+
+func parse(data []byte) (*parsed, error) {
+	if !IsEnvelope(data) || len(data) < len(magic)+1 {
+		return nil, ErrInvalidEnvelope
+	}
+
+	p := &parsed{}
+	pos := len(magic)
+	version := data[pos]
+	pos++
+
+	switch version {
+	case Version1:
+	case Version2:
+		if len(data) < pos+1 {
+			return nil, ErrInvalidEnvelope
+		}
+		kidLen := int(data[pos])
+		pos++
+		if len(data) < pos+kidLen {
+			return nil, ErrInvalidEnvelope
+		}
+		p.kid = string(data[pos : pos+kidLen])
+		pos += kidLen
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	if len(data) < pos+2 {
+		return nil, ErrInvalidEnvelope
+	}
+	keyLen := int(binary.BigEndian.Uint16(data[pos:]))
+	pos += 2
+	if len(data) < pos+keyLen+nonceSize {
+		return nil, ErrInvalidEnvelope
+	}
+
+	p.header = data[:pos+keyLen]
+	p.wrappedKey = data[pos : pos+keyLen]
+	p.nonce = data[pos+keyLen : pos+keyLen+nonceSize]
+	p.ciphertext = data[pos+keyLen+nonceSize:]
+
+	return p, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,65 @@
+package envelope_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	// Far larger than a single RSA block of a 2048-bit key.
+	plaintext := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1}`), 1000)
+
+	sealed, err := envelope.Seal(&key.PublicKey, "key-1", plaintext)
+	require.NoError(t, err)
+	assert.True(t, envelope.IsEnvelope(sealed))
+
+	kid, err := envelope.KeyID(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", kid)
+
+	opened, err := envelope.Open(key, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	t.Run("tampered ciphertext", func(t *testing.T) {
+		tampered := bytes.Clone(sealed)
+		tampered[len(tampered)-1] ^= 1
+		_, err := envelope.Open(key, tampered)
+		assert.ErrorIs(t, err, envelope.ErrInvalidEnvelope)
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		tampered := bytes.Clone(sealed)
+		tampered[4] = 3
+		_, err := envelope.Open(key, tampered)
+		assert.ErrorIs(t, err, envelope.ErrUnsupportedVersion)
+	})
+
+	t.Run("tampered key id", func(t *testing.T) {
+		tampered := bytes.Clone(sealed)
+		tampered[6] = 'x'
+		_, err := envelope.Open(key, tampered)
+		assert.ErrorIs(t, err, envelope.ErrInvalidEnvelope)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := envelope.Open(key, sealed[:20])
+		assert.ErrorIs(t, err, envelope.ErrInvalidEnvelope)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		_, err = envelope.Open(other, sealed)
+		assert.ErrorIs(t, err, envelope.ErrInvalidEnvelope)
+	})
+}
@@ -0,0 +1,75 @@
+package keys
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+)
+
+// Keyring holds the server's RSA private keys by key ID, so that payloads encrypted
+// for a retired key are still accepted while agents move to the new one.
+type Keyring struct {
+	keys  map[string]*rsa.PrivateKey
+	order []string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*rsa.PrivateKey)}
+}
+
+func LoadKeyring(paths ...string) (*Keyring, error) {
+	keyring := NewKeyring()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, kid, err := ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: payload encryption requires an RSA key, got %s", path, Describe(key.Public()))
+		}
+
+		if err = keyring.Add(kid, rsaKey); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return keyring, nil
+}
+
+func (k *Keyring) Add(kid string, key *rsa.PrivateKey) error {
+	if _, ok := k.keys[kid]; ok {
+		return fmt.Errorf("duplicate key id %q", kid)
+	}
+	k.keys[kid] = key
+	k.order = append(k.order, kid)
+
+	return nil
+}
+
+// Keys returns the key with the given ID, or every key in load order when kid is empty.
+func (k *Keyring) Keys(kid string) []*rsa.PrivateKey {
+	if kid != "" {
+		if key, ok := k.keys[kid]; ok {
+			return []*rsa.PrivateKey{key}
+		}
+		return nil
+	}
+
+	keys := make([]*rsa.PrivateKey, 0, len(k.order))
+	for _, id := range k.order {
+		keys = append(keys, k.keys[id])
+	}
+
+	return keys
+}
+
+func (k *Keyring) Len() int {
+	return len(k.keys)
+}
@@ -0,0 +1,202 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	RSA     = "rsa"
+	ECDSA   = "ecdsa"
+	Ed25519 = "ed25519"
+
+	PrivateKeyBlock = "PRIVATE KEY"
+	PublicKeyBlock  = "PUBLIC KEY"
+
+	// KeyIDHeader is the PEM header holding the key ID. Keys without it are identified
+	// by KeyID of their public key.
+	KeyIDHeader = "Key-Id"
+
+	DefaultRSABits   = 2048
+	DefaultECDSABits = 256
+)
+
+var ErrNoPEM = errors.New("no PEM data found")
+
+func Generate(alg string, bits int) (crypto.Signer, error) {
+	switch alg {
+	case RSA:
+		if bits == 0 {
+			bits = DefaultRSABits
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("RSA key size %d is too small, use at least 2048", bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case ECDSA:
+		curve, err := curveForBits(bits)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unknown key algorithm %q", alg)
+	}
+}
+
+func curveForBits(bits int) (elliptic.Curve, error) {
+	switch bits {
+	case 0, 256:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	case 521:
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported ECDSA curve size %d, use 256, 384 or 521", bits)
+	}
+}
+
+// EncodePrivateKey encodes the key as a PKCS#8 "PRIVATE KEY" block.
+func EncodePrivateKey(key crypto.PrivateKey, kid string) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: PrivateKeyBlock, Headers: kidHeaders(kid), Bytes: der}), nil
+}
+
+// EncodePublicKey encodes the key as a PKIX "PUBLIC KEY" block.
+func EncodePublicKey(key crypto.PublicKey, kid string) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: PublicKeyBlock, Headers: kidHeaders(kid), Bytes: der}), nil
+}
+
+func kidHeaders(kid string) map[string]string {
+	if kid == "" {
+		return nil
+	}
+
+	return map[string]string{KeyIDHeader: kid}
+}
+
+// ParsePrivateKey accepts PKCS#8, PKCS#1 and SEC 1 keys regardless of the block type,
+// so keys written by older versions of cmd/key still load.
+func ParsePrivateKey(data []byte) (crypto.Signer, string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", ErrNoPEM
+	}
+
+	var key any
+	var err error
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, "", fmt.Errorf("unsupported private key in %q block", block.Type)
+			}
+		}
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	kid := block.Headers[KeyIDHeader]
+	if kid == "" {
+		if kid, err = KeyID(signer.Public()); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return signer, kid, nil
+}
+
+// ParsePublicKey accepts PKIX and PKCS#1 public keys regardless of the block type.
+func ParsePublicKey(data []byte) (crypto.PublicKey, string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", ErrNoPEM
+	}
+
+	var key any
+	var err error
+	if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return nil, "", fmt.Errorf("unsupported public key in %q block", block.Type)
+		}
+	}
+
+	kid := block.Headers[KeyIDHeader]
+	if kid == "" {
+		if kid, err = KeyID(key); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return key, kid, nil
+}
+
+// Fingerprint is the SHA-256 of the PKIX encoded public key in the OpenSSH notation.
+func Fingerprint(key crypto.PublicKey) (string, error) {
+	sum, err := publicKeyHash(key)
+	if err != nil {
+		return "", err
+	}
+
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum), nil
+}
+
+// KeyID derives a short stable identifier from the public key.
+func KeyID(key crypto.PublicKey) (string, error) {
+	sum, err := publicKeyHash(key)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func publicKeyHash(key crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	return sum[:], nil
+}
+
+// Describe returns the algorithm and size of a public key, e.g. "RSA 2048".
+func Describe(key crypto.PublicKey) string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("%T", key)
+	}
+}
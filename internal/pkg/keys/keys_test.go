@@ -0,0 +1,117 @@
+package keys_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/keys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		alg  string
+		bits int
+		want string
+	}{
+		{alg: keys.RSA, bits: 2048, want: "RSA 2048"},
+		{alg: keys.ECDSA, bits: 384, want: "ECDSA P-384"},
+		{alg: keys.Ed25519, want: "Ed25519"},
+	} {
+		t.Run(tt.alg, func(t *testing.T) {
+			key, err := keys.Generate(tt.alg, tt.bits)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keys.Describe(key.Public()))
+
+			privatePEM, err := keys.EncodePrivateKey(key, "kid-1")
+			require.NoError(t, err)
+			publicPEM, err := keys.EncodePublicKey(key.Public(), "kid-1")
+			require.NoError(t, err)
+
+			parsedPrivate, kid, err := keys.ParsePrivateKey(privatePEM)
+			require.NoError(t, err)
+			assert.Equal(t, "kid-1", kid)
+
+			parsedPublic, kid, err := keys.ParsePublicKey(publicPEM)
+			require.NoError(t, err)
+			assert.Equal(t, "kid-1", kid)
+
+			want, err := keys.Fingerprint(key.Public())
+			require.NoError(t, err)
+			got, err := keys.Fingerprint(parsedPrivate.Public())
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+			got, err = keys.Fingerprint(parsedPublic)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+
+	_, err := keys.Generate(keys.RSA, 1024)
+	assert.Error(t, err)
+	_, err = keys.Generate("dsa", 0)
+	assert.Error(t, err)
+}
+
+func TestParseLegacyKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	kid, err := keys.KeyID(&key.PublicKey)
+	require.NoError(t, err)
+
+	// Formats written by the previous cmd/key and by openssl.
+	private := pem.EncodeToMemory(&pem.Block{Type: "SERVER PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	_, gotKid, err := keys.ParsePrivateKey(private)
+	require.NoError(t, err)
+	assert.Equal(t, kid, gotKid)
+
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	for _, block := range []*pem.Block{
+		{Type: "AGENT PUBLIC KEY", Bytes: pkix},
+		{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)},
+	} {
+		public, gotKid, err := keys.ParsePublicKey(pem.EncodeToMemory(block))
+		require.NoError(t, err, block.Type)
+		assert.Equal(t, &key.PublicKey, public)
+		assert.Equal(t, kid, gotKid)
+	}
+
+	_, _, err = keys.ParsePublicKey([]byte("not a key"))
+	assert.ErrorIs(t, err, keys.ErrNoPEM)
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, alg, kid string) string {
+		key, err := keys.Generate(alg, 0)
+		require.NoError(t, err)
+		data, err := keys.EncodePrivateKey(key, kid)
+		require.NoError(t, err)
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0600))
+		return path
+	}
+
+	current := write("current.pem", keys.RSA, "2024-02")
+	previous := write("previous.pem", keys.RSA, "2024-01")
+
+	keyring, err := keys.LoadKeyring(current, previous)
+	require.NoError(t, err)
+	assert.Equal(t, 2, keyring.Len())
+	assert.Len(t, keyring.Keys("2024-01"), 1)
+	assert.Len(t, keyring.Keys(""), 2)
+	assert.Empty(t, keyring.Keys("unknown"))
+
+	_, err = keys.LoadKeyring(current, current)
+	assert.Error(t, err)
+
+	_, err = keys.LoadKeyring(write("ed25519.pem", keys.Ed25519, ""))
+	assert.Error(t, err)
+}
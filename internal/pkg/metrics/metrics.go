@@ -32,6 +32,21 @@ func (m *Metrics) EncodeMetric() (*bytes.Buffer, error) {
 	return &buf, nil
 }
 
+// Copy returns a metric that shares no values with m.
+func (m *Metrics) Copy() *Metrics {
+	c := *m
+	if m.Value != nil {
+		value := *m.Value
+		c.Value = &value
+	}
+	if m.Delta != nil {
+		delta := *m.Delta
+		c.Delta = &delta
+	}
+
+	return &c
+}
+
 func (m *Metrics) String() string {
 	switch m.MType {
 	case GaugeMetricName:
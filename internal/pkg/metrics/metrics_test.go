@@ -54,3 +54,20 @@ func TestMetric_EncodeMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestMetric_Copy(t *testing.T) {
+	value := Gauge(1.5)
+	delta := Counter(3)
+	m := &Metrics{ID: "Alloc", MType: GaugeMetricName, Value: &value, Delta: &delta}
+
+	c := m.Copy()
+	value, delta = 0, 0
+
+	if !reflect.DeepEqual(c, &Metrics{ID: "Alloc", MType: GaugeMetricName, Value: ptr(Gauge(1.5)), Delta: ptr(Counter(3))}) {
+		t.Errorf("Copy() = %v, it shares values with the original", c)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
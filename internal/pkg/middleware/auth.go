@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/sirupsen/logrus"
+)
+
+// AuthMiddleware requires a bearer token with the scope returned by scopeFor, and
+// puts the token into the request context. Requests for which scopeFor returns an
+// empty scope are served without a token.
+func AuthMiddleware(a *auth.Authenticator, scopeFor func(r *http.Request) auth.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope := scopeFor(r)
+			if scope == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, err := a.Authorize(r.Context(), auth.BearerToken(r.Header.Get("Authorization")), scope)
+			switch {
+			case errors.Is(err, auth.ErrUnauthenticated):
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			case errors.Is(err, auth.ErrForbidden):
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			case err != nil:
+				logrus.Errorf("Error authenticate request: %v", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithToken(r.Context(), token)))
+		})
+	}
+}
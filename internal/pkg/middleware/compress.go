@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+	encodingZstd    = "zstd"
+)
+
+// compressedTypes are the response content types worth compressing. Event streams
+// are left alone, they are flushed event by event.
+var compressedTypes = map[string]bool{
+	"text/html":        true,
+	"application/json": true,
+}
+
+// DecompressMiddleware decodes request bodies sent with a gzip, deflate or zstd
+// Content-Encoding, so that signatures, decryption and handlers see the bytes the
+// client encoded. The decoded body is capped at limit bytes like BodyLimitMiddleware
+// caps the encoded one; a limit of zero or less disables the cap.
+func DecompressMiddleware(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if encoding == "" || encoding == "identity" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := decodeBody(encoding, r.Body)
+			if err != nil {
+				status := http.StatusBadRequest
+				if errors.Is(err, errUnsupportedEncoding) {
+					status = http.StatusUnsupportedMediaType
+				}
+				http.Error(w, fmt.Sprintf("cannot decode %s body: %v", encoding, err), status)
+				return
+			}
+			defer body.Close()
+
+			r.Body = body
+			if limit > 0 {
+				r.Body = http.MaxBytesReader(w, body, limit)
+			}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+var errUnsupportedEncoding = errors.New("unsupported encoding")
+
+func decodeBody(encoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	var (
+		decoded io.ReadCloser
+		err     error
+	)
+
+	switch encoding {
+	case encodingGzip, "x-gzip":
+		decoded, err = gzip.NewReader(body)
+	case encodingDeflate:
+		decoded, err = zlib.NewReader(body)
+	case encodingZstd:
+		var dec *zstd.Decoder
+		dec, err = zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err == nil {
+			decoded = dec.IOReadCloser()
+		}
+	default:
+		return nil, errUnsupportedEncoding
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &decodedBody{ReadCloser: decoded, body: body}, nil
+}
+
+// decodedBody closes both the decoder and the original body.
+type decodedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if bodyErr := b.body.Close(); err == nil {
+		err = bodyErr
+	}
+
+	return err
+}
+
+// CompressMiddleware compresses HTML and JSON responses with the best encoding the
+// client accepts. It has to run outside of CryptMiddleware: response signatures are
+// computed over the uncompressed body, which is what HTTP clients hand to callers.
+func CompressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Values("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks the supported encoding with the highest quality, preferring
+// zstd, gzip and deflate in that order on ties.
+func negotiateEncoding(accept []string) string {
+	quality := make(map[string]float64)
+	for _, header := range accept {
+		for _, part := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				parsed, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+			quality[strings.ToLower(strings.TrimSpace(name))] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range []string{encodingZstd, encodingGzip, encodingDeflate} {
+		q, ok := quality[encoding]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// compressWriter decides on the first write whether the response is compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	decided bool
+	encoder io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if !w.decided {
+		w.decide(status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encoder == nil {
+		return w.ResponseWriter.Write(p)
+	}
+
+	return w.encoder.Write(p)
+}
+
+func (w *compressWriter) Flush() {
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			logrus.Errorf("Cannot flush compressed response: %v", err)
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) decide(status int) {
+	w.decided = true
+
+	h := w.Header()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" {
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || !compressedTypes[mediaType] {
+		return
+	}
+
+	switch w.encoding {
+	case encodingZstd:
+		enc, err := zstd.NewWriter(w.ResponseWriter, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			logrus.Errorf("Cannot compress response: %v", err)
+			return
+		}
+		w.encoder = enc
+	case encodingGzip:
+		w.encoder = gzip.NewWriter(w.ResponseWriter)
+	case encodingDeflate:
+		w.encoder = zlib.NewWriter(w.ResponseWriter)
+	}
+
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+}
+
+func (w *compressWriter) close() {
+	if w.encoder == nil {
+		return
+	}
+	if err := w.encoder.Close(); err != nil {
+		logrus.Errorf("Cannot finish compressed response: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package middleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const payload = `[{"id":"Alloc","type":"gauge","value":1}]`
+
+func encode(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "zstd":
+		enc, err := zstd.NewWriter(&buf)
+		require.NoError(t, err)
+		w = enc
+	default:
+		return data
+	}
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func decode(t *testing.T, encoding string, data []byte) string {
+	t.Helper()
+
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	case "zstd":
+		r, err = zstd.NewReader(bytes.NewReader(data))
+	default:
+		return string(data)
+	}
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	return string(decoded)
+}
+
+func TestDecompressMiddleware(t *testing.T) {
+	var got string
+	handler := middleware.DecompressMiddleware(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), middleware.BodyErrorStatus(err))
+			return
+		}
+		got = string(body)
+	}))
+
+	for _, encoding := range []string{"", "gzip", "deflate", "zstd"} {
+		t.Run("encoding "+encoding, func(t *testing.T) {
+			got = ""
+			r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(encode(t, encoding, []byte(payload))))
+			r.Header.Set("Content-Encoding", encoding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, payload, got)
+		})
+	}
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		want     int
+	}{
+		{name: "unsupported encoding", encoding: "br", body: []byte(payload), want: http.StatusUnsupportedMediaType},
+		{name: "corrupt body", encoding: "gzip", body: []byte(payload), want: http.StatusBadRequest},
+		{
+			name:     "decoded body over the limit",
+			encoding: "gzip",
+			body:     encode(t, "gzip", bytes.Repeat([]byte("a"), 4096)),
+			want:     http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", tt.encoding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	handler := middleware.CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		_, _ = w.Write([]byte(payload))
+	}))
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		want        string
+	}{
+		{name: "gzip", accept: "gzip", contentType: "application/json", want: "gzip"},
+		{name: "preferred zstd", accept: "gzip, deflate, zstd", contentType: "application/json", want: "zstd"},
+		{name: "quality", accept: "zstd;q=0.5, deflate", contentType: "text/html; charset=utf-8", want: "deflate"},
+		{name: "refused", accept: "gzip;q=0, *;q=0", contentType: "application/json"},
+		{name: "nothing accepted", contentType: "application/json"},
+		{name: "event stream", accept: "gzip", contentType: "text/event-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?type="+url.QueryEscape(tt.contentType), nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			assert.Equal(t, tt.want, rec.Header().Get("Content-Encoding"))
+			assert.Equal(t, payload, decode(t, tt.want, rec.Body.Bytes()))
+		})
+	}
+}
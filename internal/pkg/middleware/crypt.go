@@ -2,76 +2,188 @@ package middleware
 
 import (
 	"bytes"
-	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/envelope"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/keys"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
+	"github.com/sirupsen/logrus"
 )
 
-func CryptMiddleware(signKey []byte) func(handler http.Handler) http.Handler {
+// CryptMiddleware verifies the HashSHA256 header, an HMAC over the X-Timestamp and
+// X-Nonce headers and the body. The requests selected by restricted have to be signed,
+// others are verified when they carry the header. With a replay guard, requests outside
+// its window or reusing a nonce are rejected. Responses to signed requests are buffered
+// and signed with sign.SumResponse in their HashSHA256 header.
+func CryptMiddleware(
+	signKey []byte,
+	replay *sign.ReplayGuard,
+	restricted func(r *http.Request) bool,
+) func(handler http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if signKey == nil {
+				next.ServeHTTP(w, r)
 				return
 			}
 
-			if r.Header.Get("HashSHA256") == "" {
+			if r.Header.Get(sign.HashHeader) == "" {
+				if restricted(r) {
+					http.Error(w, "HashSHA256 header is required", http.StatusUnauthorized)
+					return
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
 			body, err := io.ReadAll(r.Body)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				http.Error(w, err.Error(), BodyErrorStatus(err))
 				return
 			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
 
-			if len(body) == 0 {
+			if len(body) == 0 && !restricted(r) {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			h := hmac.New(sha256.New, signKey)
-			h.Write(body)
-			serverHash := h.Sum(nil)
-
-			hash, err := hex.DecodeString(r.Header.Get("HashSHA256"))
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+			timestamp := r.Header.Get(sign.TimestampHeader)
+			nonce := r.Header.Get(sign.NonceHeader)
+			if timestamp == "" && nonce == "" {
+				http.Error(w, sign.ErrLegacySignature.Error(), http.StatusUnauthorized)
 				return
 			}
-
-			if !hmac.Equal(hash, serverHash) {
+			if !sign.VerifyRequest(signKey, r.Header.Get(sign.HashHeader), timestamp, nonce, body) {
 				http.Error(w, "Invalid HashSHA256 header value", http.StatusBadRequest)
 				return
 			}
 
-			w.Header().Set("HashSHA256", hex.EncodeToString(serverHash))
+			if replay != nil {
+				if err = replay.Check(timestamp, nonce, time.Now()); err != nil {
+					http.Error(w, err.Error(), replayStatus(err))
+					return
+				}
+			}
 
-			next.ServeHTTP(w, r)
+			sw := &signingWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			sw.flush(signKey, r.Header.Get(sign.HashHeader))
 		})
 	}
 }
 
-func DecryptMiddleware(key *rsa.PrivateKey) func(http.Handler) http.Handler {
+type signingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *signingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *signingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.body.Write(p)
+}
+
+func (w *signingWriter) flush(key []byte, requestSignature string) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	w.Header().Set(sign.HashHeader, sign.SumResponse(key, w.status, requestSignature, w.body.Bytes()))
+	w.Header().Set("Content-Length", strconv.Itoa(w.body.Len()))
+	w.ResponseWriter.WriteHeader(w.status)
+	if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+		logrus.Errorf("Cannot send response: %v", err)
+	}
+}
+
+func replayStatus(err error) int {
+	switch {
+	case errors.Is(err, sign.ErrReplayedRequest):
+		return http.StatusConflict
+	case errors.Is(err, sign.ErrStaleRequest):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// DecryptMiddleware opens envelope encrypted bodies with the key named in the envelope.
+// Bodies of exactly one RSA block are still accepted as raw PKCS#1 v1.5 ciphertext sent
+// by older agents.
+func DecryptMiddleware(keyring *keys.Keyring) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			body, err := io.ReadAll(r.Body)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				http.Error(w, err.Error(), BodyErrorStatus(err))
+				return
+			}
+
+			if len(body) == 0 {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				next.ServeHTTP(w, r)
 				return
 			}
 
-			plaintext, err := rsa.DecryptPKCS1v15(rand.Reader, key, body)
+			plaintext, err := decrypt(keyring, body)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 
 			r.Body = io.NopCloser(bytes.NewBuffer(plaintext))
+			r.ContentLength = int64(len(plaintext))
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+func decrypt(keyring *keys.Keyring, body []byte) ([]byte, error) {
+	if envelope.IsEnvelope(body) {
+		kid, err := envelope.KeyID(body)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates := keyring.Keys(kid)
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+
+		var plaintext []byte
+		for _, key := range candidates {
+			if plaintext, err = envelope.Open(key, body); err == nil {
+				return plaintext, nil
+			}
+		}
+
+		return nil, err
+	}
+
+	for _, key := range keyring.Keys("") {
+		if len(body) != key.Size() {
+			continue
+		}
+		if plaintext, err := rsa.DecryptPKCS1v15(rand.Reader, key, body); err == nil {
+			return plaintext, nil
+		}
+	}
+
+	return nil, envelope.ErrInvalidEnvelope
+}
@@ -0,0 +1,149 @@
+package middleware_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/envelope"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/keys"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/middleware"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecryptMiddleware(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	retired, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keyring := keys.NewKeyring()
+	require.NoError(t, keyring.Add("current", key))
+	require.NoError(t, keyring.Add("retired", retired))
+
+	handler := middleware.DecryptMiddleware(keyring)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+
+	payload := bytes.Repeat([]byte("metric"), 500)
+	sealed, err := envelope.Seal(&key.PublicKey, "current", payload)
+	require.NoError(t, err)
+	sealedRetired, err := envelope.Seal(&retired.PublicKey, "retired", payload)
+	require.NoError(t, err)
+	sealedUnknown, err := envelope.Seal(&key.PublicKey, "unknown", payload)
+	require.NoError(t, err)
+
+	legacyPayload := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	legacy, err := rsa.EncryptPKCS1v15(rand.Reader, &retired.PublicKey, legacyPayload)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		body []byte
+		code int
+		want []byte
+	}{
+		{name: "envelope", body: sealed, code: http.StatusOK, want: payload},
+		{name: "envelope for retired key", body: sealedRetired, code: http.StatusOK, want: payload},
+		{name: "unknown key id", body: sealedUnknown, code: http.StatusBadRequest},
+		{name: "legacy PKCS#1 v1.5", body: legacy, code: http.StatusOK, want: legacyPayload},
+		{name: "empty body", body: nil, code: http.StatusOK},
+		{name: "plaintext", body: payload, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body)))
+
+			assert.Equal(t, tt.code, rec.Code)
+			if tt.code == http.StatusOK {
+				assert.Equal(t, string(tt.want), rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCryptMiddlewareReplay(t *testing.T) {
+	key := []byte("secret")
+	handler := middleware.CryptMiddleware(key, sign.NewReplayGuard(time.Minute, 100), isPost)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	send := func(timestamp, nonce string) int {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		req.Header.Set(sign.TimestampHeader, timestamp)
+		req.Header.Set(sign.NonceHeader, nonce)
+		req.Header.Set(sign.HashHeader, sign.SumRequest(key, timestamp, nonce, body))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	now := sign.Timestamp(time.Now())
+	assert.Equal(t, http.StatusOK, send(now, "n1"))
+	assert.Equal(t, http.StatusConflict, send(now, "n1"))
+	assert.Equal(t, http.StatusUnauthorized, send(sign.Timestamp(time.Now().Add(-time.Hour)), "n2"))
+	assert.Equal(t, http.StatusUnauthorized, send("", ""))
+}
+
+func TestCryptMiddlewareSignsResponse(t *testing.T) {
+	key := []byte("secret")
+	handler := middleware.CryptMiddleware(key, nil, isPost)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("accepted"))
+	}))
+
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	signature := sign.SumRequest(key, "1", "n", body)
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	req.Header.Set(sign.TimestampHeader, "1")
+	req.Header.Set(sign.NonceHeader, "n")
+	req.Header.Set(sign.HashHeader, signature)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "accepted", rec.Body.String())
+	got := rec.Header().Get(sign.HashHeader)
+	assert.True(t, sign.VerifyResponse(key, got, http.StatusAccepted, signature, []byte("accepted")))
+	assert.False(t, sign.VerifyResponse(key, got, http.StatusOK, signature, []byte("accepted")))
+	assert.False(t, sign.VerifyResponse(key, got, http.StatusAccepted, sign.Sum(key, body), []byte("accepted")))
+}
+
+func TestCryptMiddlewareRequiresSignedWrites(t *testing.T) {
+	key := []byte("secret")
+	handler := middleware.CryptMiddleware(key, sign.NewReplayGuard(time.Minute, 100), isPost)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	send := func(method, target string, body []byte, signature string) int {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		if signature != "" {
+			req.Header.Set(sign.HashHeader, signature)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodPost, "/updates/", body, ""))
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodPost, "/update/counter/PollCount/1", nil, ""))
+	// Agents that sign the body alone are told to upgrade.
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodPost, "/updates/", body, sign.Sum(key, body)))
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/", nil, ""))
+}
+
+func isPost(r *http.Request) bool {
+	return r.Method == http.MethodPost
+}
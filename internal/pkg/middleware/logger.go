@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/certs"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"time"
@@ -12,6 +13,9 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 		t := time.Now()
 		logrus.Infof("requset with method %s: %s, duration %s", r.RequestURI, r.Method, time.Since(t))
+		if identity := certs.RequestIdentity(r); identity != "" {
+			logrus.Infof("client certificate: %s", identity)
+		}
 
 		logrus.Infof("response status : %d, size : %d", ww.Status(), ww.BytesWritten())
 		next.ServeHTTP(ww, r)
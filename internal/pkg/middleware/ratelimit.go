@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/ratelimit"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
+)
+
+// RateLimitMiddleware identifies the client of the requests selected by restricted,
+// so that the ratelimit.Store quotas apply to it, and rejects the request with 429
+// once the client's bucket is empty. A nil limiter only identifies clients.
+func RateLimitMiddleware(
+	limiter *ratelimit.Limiter,
+	resolver subnet.Resolver,
+	restricted func(r *http.Request) bool,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !restricted(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := ratelimit.RequestClient(r, resolver)
+			if limiter != nil {
+				if ok, wait := limiter.Allow(client, time.Now()); !ok {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(ratelimit.WithClient(r.Context(), client)))
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/middleware"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/ratelimit"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	isWrite := func(r *http.Request) bool { return r.Method == http.MethodPost }
+
+	var client string
+	handler := middleware.RateLimitMiddleware(ratelimit.NewLimiter(0.5, 1), subnet.Resolver{TrustRealIP: true}, isWrite)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, _ = ratelimit.ClientFromContext(r.Context())
+		}))
+
+	serve := func(method, ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/updates/", nil)
+		r.Header.Set(subnet.RealIPHeader, ip)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "10.0.0.1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ip:10.0.0.1", client)
+
+	rec = serve(http.MethodPost, "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "10.0.0.2").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "10.0.0.1").Code)
+}
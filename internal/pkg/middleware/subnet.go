@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
+	"github.com/sirupsen/logrus"
+)
+
+// TrustedSubnetMiddleware rejects the requests selected by restricted whose client
+// address is outside the trusted networks. An empty set disables the check.
+func TrustedSubnetMiddleware(
+	trusted subnet.Set,
+	resolver subnet.Resolver,
+	restricted func(r *http.Request) bool,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(trusted) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !restricted(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ip := resolver.RequestIP(r)
+			if !trusted.Contains(ip) {
+				logrus.Infof("Rejected request from %s outside trusted subnet", ip)
+				http.Error(w, "client address is not in trusted subnet", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/middleware"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	trusted, err := subnet.Parse("192.168.1.0/24")
+	require.NoError(t, err)
+
+	isWrite := func(r *http.Request) bool { return r.Method == http.MethodPost }
+	handler := middleware.TrustedSubnetMiddleware(trusted, subnet.Resolver{TrustRealIP: true}, isWrite)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		method string
+		realIP string
+		want   int
+	}{
+		{name: "write from trusted subnet", method: http.MethodPost, realIP: "192.168.1.5", want: http.StatusOK},
+		{name: "write from outside", method: http.MethodPost, realIP: "10.0.0.1", want: http.StatusForbidden},
+		{name: "write without address", method: http.MethodPost, want: http.StatusForbidden},
+		{name: "read from outside", method: http.MethodGet, realIP: "10.0.0.1", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/updates/", nil)
+			if tt.realIP != "" {
+				r.Header.Set(subnet.RealIPHeader, tt.realIP)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/certs"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
+)
+
+type clientKey struct{}
+
+// requestClient is the client of one request along with the number of metrics the
+// request has written so far, a request may write its metrics in several calls.
+type requestClient struct {
+	id      string
+	batched atomic.Int64
+}
+
+// WithClient marks ctx as a request of client. Batch limits apply to all updates made
+// with the returned context together.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, &requestClient{id: client})
+}
+
+func ClientFromContext(ctx context.Context) (string, bool) {
+	if c := fromContext(ctx); c != nil {
+		return c.id, true
+	}
+	return "", false
+}
+
+func fromContext(ctx context.Context) *requestClient {
+	c, _ := ctx.Value(clientKey{}).(*requestClient)
+	return c
+}
+
+// RequestClient identifies the agent behind a request by its API token, its client
+// certificate or, failing both, its address.
+func RequestClient(r *http.Request, resolver subnet.Resolver) string {
+	if client := contextClient(r.Context()); client != "" {
+		return client
+	}
+	if cn := certs.RequestIdentity(r); cn != "" {
+		return "cert:" + cn
+	}
+
+	return "ip:" + resolver.RequestIP(r).String()
+}
+
+// PeerClient is RequestClient for gRPC calls, ip is the resolved client address.
+func PeerClient(ctx context.Context, ip string) string {
+	if client := contextClient(ctx); client != "" {
+		return client
+	}
+	if cn := certs.PeerIdentity(ctx); cn != "" {
+		return "cert:" + cn
+	}
+
+	return "ip:" + ip
+}
+
+func contextClient(ctx context.Context) string {
+	if t, ok := auth.FromContext(ctx); ok {
+		return "token:" + t.ID
+	}
+
+	return ""
+}
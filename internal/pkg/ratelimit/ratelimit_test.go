@@ -0,0 +1,111 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/ratelimit"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := ratelimit.NewLimiter(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("a", now)
+		require.True(t, ok)
+	}
+
+	ok, wait := limiter.Allow("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Other clients have their own buckets.
+	ok, _ = limiter.Allow("b", now)
+	assert.True(t, ok)
+
+	ok, _ = limiter.Allow("a", now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	ok, _ = limiter.Allow("a", now.Add(500*time.Millisecond))
+	assert.False(t, ok)
+
+	// Refilled buckets are forgotten and start full again.
+	for i := 0; i < 3; i++ {
+		ok, _ = limiter.Allow("a", now.Add(time.Hour))
+		assert.True(t, ok)
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := ratelimit.NewStore(storage.NewMetrics(), 2, 3, time.Hour)
+	request := func(client string) context.Context {
+		return ratelimit.WithClient(context.Background(), client)
+	}
+
+	gauge := func(name string) *metrics.Metrics {
+		v := metrics.Gauge(1)
+		return &metrics.Metrics{ID: name, MType: metrics.GaugeMetricName, Value: &v}
+	}
+
+	err := store.UpdateMetrics(request("token:a"), []*metrics.Metrics{gauge("x"), gauge("y"), gauge("z")})
+	assert.ErrorIs(t, err, ratelimit.ErrBatchTooLarge)
+
+	// The batch limit covers all chunks of a request.
+	ctx := request("token:a")
+	require.NoError(t, store.UpdateMetrics(ctx, []*metrics.Metrics{gauge("x")}))
+	require.NoError(t, store.UpdateMetrics(ctx, []*metrics.Metrics{gauge("y")}))
+	assert.ErrorIs(t, store.UpdateMetrics(ctx, []*metrics.Metrics{gauge("x")}), ratelimit.ErrBatchTooLarge)
+
+	require.NoError(t, store.UpdateCounterMetric(request("token:a"), "c", 1))
+
+	// Known series are always accepted, new ones only within the quota.
+	assert.NoError(t, store.UpdateGaugeMetric(request("token:a"), "x", 2))
+	assert.ErrorIs(t, store.UpdateGaugeMetric(request("token:a"), "z", 1), ratelimit.ErrSeriesQuota)
+	assert.ErrorIs(t, store.UpdateMetrics(request("token:a"), []*metrics.Metrics{gauge("x"), gauge("w")}),
+		ratelimit.ErrSeriesQuota)
+
+	// Refused batches don't count towards the batch limit.
+	ctx = request("token:a")
+	assert.ErrorIs(t, store.UpdateMetrics(ctx, []*metrics.Metrics{gauge("x"), gauge("w")}), ratelimit.ErrSeriesQuota)
+	assert.NoError(t, store.UpdateMetrics(ctx, []*metrics.Metrics{gauge("x"), gauge("y")}))
+
+	// Quotas are per client, internal updates are not limited.
+	assert.NoError(t, store.UpdateGaugeMetric(request("token:b"), "z", 1))
+	assert.NoError(t, store.UpdateMetrics(context.Background(),
+		[]*metrics.Metrics{gauge("q"), gauge("r"), gauge("s"), gauge("t")}))
+}
+
+// failingStore refuses the writes of the metrics in fail.
+type failingStore struct {
+	storage.Store
+	fail map[string]bool
+}
+
+func (s *failingStore) UpdateGaugeMetric(ctx context.Context, name string, value metrics.Gauge) error {
+	if s.fail[name] {
+		return errors.New("write failed")
+	}
+	return s.Store.UpdateGaugeMetric(ctx, name, value)
+}
+
+func TestStoreSeriesQuota(t *testing.T) {
+	inner := &failingStore{Store: storage.NewMetrics(), fail: map[string]bool{"x": true}}
+	store := ratelimit.NewStore(inner, 0, 1, 20*time.Millisecond)
+	ctx := ratelimit.WithClient(context.Background(), "token:a")
+
+	// A failed write gives its series back.
+	assert.Error(t, store.UpdateGaugeMetric(ctx, "x", 1))
+	require.NoError(t, store.UpdateGaugeMetric(ctx, "y", 1))
+	assert.ErrorIs(t, store.UpdateGaugeMetric(ctx, "z", 1), ratelimit.ErrSeriesQuota)
+
+	// A series the client stopped writing leaves the quota.
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, store.UpdateGaugeMetric(ctx, "z", 1))
+	assert.ErrorIs(t, store.UpdateGaugeMetric(ctx, "y", 1), ratelimit.ErrSeriesQuota)
+}
@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+)
+
+var (
+	ErrBatchTooLarge = errors.New("batch is too large")
+	ErrSeriesQuota   = errors.New("series quota exceeded")
+)
+
+// Store limits the size of update batches and the number of distinct series each
+// client identified in the context may create. The batch limit counts all metrics
+// updated by one request, however many calls it makes. A series counts towards the
+// quota from the first successful write until the client hasn't written it for
+// seriesTTL. Updates without a client are internal and not limited. Zero limits
+// disable the checks.
+type Store struct {
+	storage.Store
+	maxBatch  int
+	maxSeries int
+	seriesTTL time.Duration
+
+	lock      sync.Mutex
+	series    map[string]map[string]*series
+	lastSweep time.Time
+}
+
+// series is a series of one client. A write being applied reserves its new series, so
+// that concurrent requests can't exceed the quota together.
+type series struct {
+	last    time.Time
+	writers int
+	written bool
+}
+
+func NewStore(s storage.Store, maxBatch, maxSeries int, seriesTTL time.Duration) *Store {
+	return &Store{
+		Store:     s,
+		maxBatch:  maxBatch,
+		maxSeries: maxSeries,
+		seriesTTL: seriesTTL,
+		series:    make(map[string]map[string]*series),
+	}
+}
+
+func (s *Store) UpdateCounterMetric(ctx context.Context, name string, value metrics.Counter) error {
+	keys := []string{seriesKey(name, metrics.CounterMetricName)}
+	if err := s.admit(ctx, keys); err != nil {
+		return err
+	}
+
+	err := s.Store.UpdateCounterMetric(ctx, name, value)
+	s.done(ctx, keys, err == nil)
+
+	return err
+}
+
+func (s *Store) UpdateGaugeMetric(ctx context.Context, name string, value metrics.Gauge) error {
+	keys := []string{seriesKey(name, metrics.GaugeMetricName)}
+	if err := s.admit(ctx, keys); err != nil {
+		return err
+	}
+
+	err := s.Store.UpdateGaugeMetric(ctx, name, value)
+	s.done(ctx, keys, err == nil)
+
+	return err
+}
+
+func (s *Store) UpdateMetrics(ctx context.Context, metricBatch []*metrics.Metrics) error {
+	if err := s.count(ctx, len(metricBatch)); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(metricBatch))
+	for _, m := range metricBatch {
+		keys = append(keys, seriesKey(m.ID, m.MType))
+	}
+	err := s.admit(ctx, keys)
+	if err == nil {
+		err = s.Store.UpdateMetrics(ctx, metricBatch)
+		s.done(ctx, keys, err == nil)
+	}
+	if err != nil {
+		// Batches are applied atomically, a refused one doesn't count.
+		s.count(ctx, -len(metricBatch))
+	}
+
+	return err
+}
+
+// count adds n metrics to the request's batch, unless that exceeds the limit.
+func (s *Store) count(ctx context.Context, n int) error {
+	c := fromContext(ctx)
+	if c == nil || s.maxBatch <= 0 {
+		return nil
+	}
+
+	if total := c.batched.Add(int64(n)); total > int64(s.maxBatch) {
+		c.batched.Add(int64(-n))
+		return fmt.Errorf("%w: %d metrics, at most %d are allowed", ErrBatchTooLarge, total, s.maxBatch)
+	}
+
+	return nil
+}
+
+// admit reserves the series of the client for a write, or rejects all of them if the
+// new ones don't fit into the quota. Every admitted write must be followed by done.
+func (s *Store) admit(ctx context.Context, keys []string) error {
+	client, ok := ClientFromContext(ctx)
+	if !ok || s.maxSeries <= 0 {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	known := s.series[client]
+	unique := make(map[string]struct{})
+	for _, k := range keys {
+		if _, ok := known[k]; !ok {
+			unique[k] = struct{}{}
+		}
+	}
+	if len(known)+len(unique) > s.maxSeries {
+		return fmt.Errorf("%w: client %s may write at most %d series", ErrSeriesQuota, client, s.maxSeries)
+	}
+
+	if known == nil {
+		known = make(map[string]*series, len(unique))
+		s.series[client] = known
+	}
+	for k := range unique {
+		known[k] = &series{}
+	}
+	for _, k := range keys {
+		known[k].last = now
+		known[k].writers++
+	}
+
+	return nil
+}
+
+// done ends a write admitted by admit. The series a failed write reserved are given
+// back unless another write keeps them.
+func (s *Store) done(ctx context.Context, keys []string, ok bool) {
+	client, hasClient := ClientFromContext(ctx)
+	if !hasClient || s.maxSeries <= 0 {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	known := s.series[client]
+	for _, k := range keys {
+		ser := known[k]
+		ser.writers--
+		ser.written = ser.written || ok
+		if ser.writers == 0 && !ser.written {
+			delete(known, k)
+		}
+	}
+	if len(known) == 0 {
+		delete(s.series, client)
+	}
+}
+
+// sweep forgets the series the clients stopped writing.
+func (s *Store) sweep(now time.Time) {
+	if s.seriesTTL <= 0 || now.Sub(s.lastSweep) < min(sweepInterval, s.seriesTTL) {
+		return
+	}
+	s.lastSweep = now
+
+	for client, known := range s.series {
+		for k, ser := range known {
+			if ser.writers == 0 && now.Sub(ser.last) > s.seriesTTL {
+				delete(known, k)
+			}
+		}
+		if len(known) == 0 {
+			delete(s.series, client)
+		}
+	}
+}
+
+func seriesKey(name, metricType string) string {
+	return metricType + "/" + name
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+)
+
+var errInvalidBatch = errors.New("invalid batch")
+
+// decodeBatch reads a JSON array of metrics and checks every metric. The batch is
+// returned only when all of it is valid, so that it is applied as a whole or not at
+// all; its size is bounded by the request body limit.
+func decodeBatch(r io.Reader) ([]*metrics.Metrics, error) {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("%w: a JSON array is expected", errInvalidBatch)
+	}
+
+	var batch []*metrics.Metrics
+	for i := 0; dec.More(); i++ {
+		var m metrics.Metrics
+		if err = dec.Decode(&m); err != nil {
+			return nil, decodeError(err)
+		}
+		if err = validateMetric(&m); err != nil {
+			return nil, fmt.Errorf("%w: metric %d: %v", errInvalidBatch, i, err)
+		}
+		batch = append(batch, &m)
+	}
+
+	if _, err = dec.Token(); err != nil {
+		return nil, decodeError(err)
+	}
+
+	return batch, nil
+}
+
+func validateMetric(m *metrics.Metrics) error {
+	if m.ID == "" {
+		return errors.New("metric id is required")
+	}
+
+	switch m.MType {
+	case metrics.GaugeMetricName:
+		if m.Value == nil {
+			return errors.New("value is required for a gauge")
+		}
+	case metrics.CounterMetricName:
+		if m.Delta == nil {
+			return errors.New("delta is required for a counter")
+		}
+	default:
+		return fmt.Errorf("unknown metric type: %s", m.MType)
+	}
+
+	return nil
+}
+
+// decodeError keeps read errors such as *http.MaxBytesError intact and marks the
+// rest as malformed input.
+func decodeError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %v", errInvalidBatch, err)
+}
@@ -0,0 +1,150 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/middleware"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/server"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchStore records the size of every batch it is asked to update.
+type batchStore struct {
+	storage.Store
+	sizes []int
+}
+
+func (s *batchStore) UpdateMetrics(ctx context.Context, batch []*metrics.Metrics) error {
+	s.sizes = append(s.sizes, len(batch))
+	return s.Store.UpdateMetrics(ctx, batch)
+}
+
+func gaugeBatch(from, to int) string {
+	items := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		items = append(items, fmt.Sprintf(`{"id":"g%d","type":"gauge","value":%d}`, i, i))
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func TestUpdatesBatchHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "batch", body: gaugeBatch(0, 10), want: http.StatusOK},
+		{name: "empty batch", body: "[]", want: http.StatusOK},
+		{name: "not an array", body: `{"id":"g","type":"gauge","value":1}`, want: http.StatusBadRequest},
+		{name: "malformed", body: `[{"id":"g","type":"gauge","value":1}`, want: http.StatusBadRequest},
+		{name: "missing value", body: `[{"id":"g","type":"gauge"}]`, want: http.StatusBadRequest},
+		{name: "unknown type", body: `[{"id":"g","type":"histogram","value":1}]`, want: http.StatusBadRequest},
+		{
+			name: "invalid metric at the end",
+			body: strings.TrimSuffix(gaugeBatch(0, 500), "]") + `,{"id":""}]`,
+			want: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := chi.NewRouter()
+			server.RegisterHandlers(mux, storage.NewMetrics())
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.want, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestUpdatesBatchHandlerAppliesWholeBatch(t *testing.T) {
+	store := &batchStore{Store: storage.NewMetrics()}
+	mux := chi.NewRouter()
+	server.RegisterHandlers(mux, store)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(gaugeBatch(0, 1200))))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []int{1200}, store.sizes)
+
+	// A batch with an invalid metric is not applied at all.
+	rec = httptest.NewRecorder()
+	body := strings.TrimSuffix(gaugeBatch(0, 500), "]") + `,{"id":""}]`
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, []int{1200}, store.sizes)
+}
+
+// failingStore fails every batch update with err.
+type failingStore struct {
+	storage.Store
+	err error
+}
+
+func (s *failingStore) UpdateMetrics(context.Context, []*metrics.Metrics) error {
+	return s.err
+}
+
+func TestUpdatesBatchHandlerStoreErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "database down", err: errors.New("connection refused"), want: http.StatusInternalServerError},
+		{name: "timed out", err: fmt.Errorf("update: %w", context.DeadlineExceeded), want: http.StatusServiceUnavailable},
+		{name: "type mismatch", err: fmt.Errorf("%w g:counter", storage.ErrMetricTypeMismatch), want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := chi.NewRouter()
+			server.RegisterHandlers(mux, &failingStore{Store: storage.NewMetrics(), err: tt.err})
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(gaugeBatch(0, 1))))
+
+			assert.Equal(t, tt.want, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestUpdatesBatchHandlerBodyLimit(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(middleware.BodyLimitMiddleware(1024))
+	server.RegisterHandlers(mux, storage.NewMetrics())
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, chunked := range []bool{false, true} {
+		t.Run(fmt.Sprintf("chunked=%v", chunked), func(t *testing.T) {
+			body := gaugeBatch(0, 100)
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(body))
+			require.NoError(t, err)
+			if chunked {
+				req.ContentLength = -1
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		})
+	}
+
+	resp, err := http.Post(ts.URL+"/updates/", "application/json", strings.NewReader(gaugeBatch(0, 10)))
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
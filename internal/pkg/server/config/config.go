@@ -1,14 +1,14 @@
 package config
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
 	"flag"
 	"os"
+	"strings"
 
 	"github.com/caarlos0/env/v6"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/keys"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
 	"github.com/sirupsen/logrus"
 )
 
@@ -22,28 +22,47 @@ type ServerConfig struct {
 	PrivateKey      string `env:"CRYPTO_KEY" json:"crypto_key"`
 	ConfigPath      string `env:"CONFIG"`
 	SignKeyByte     []byte
-	GRPCAddress     string `yaml:"address" env:"GRPC_ADDRESS"`
+	GRPCAddress     string  `yaml:"address" env:"GRPC_ADDRESS"`
+	TrustedSubnet   string  `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	TrustedProxies  string  `env:"TRUSTED_PROXIES" json:"trusted_proxies"`
+	TrustRealIP     bool    `env:"TRUST_REAL_IP" json:"trust_real_ip"`
+	TLSCertFile     string  `env:"TLS_CERT" json:"tls_cert"`
+	TLSKeyFile      string  `env:"TLS_KEY" json:"tls_key"`
+	TLSClientCAFile string  `env:"TLS_CLIENT_CA" json:"tls_client_ca"`
+	ReplayWindow    int     `env:"REPLAY_WINDOW" json:"replay_window"`
+	TokensFile      string  `env:"TOKENS_FILE" json:"tokens_file"`
+	TokensDB        bool    `env:"TOKENS_DB" json:"tokens_db"`
+	AdminToken      string  `env:"ADMIN_TOKEN"`
+	ClientRateLimit float64 `env:"CLIENT_RATE_LIMIT" json:"client_rate_limit"`
+	ClientRateBurst int     `env:"CLIENT_RATE_BURST" json:"client_rate_burst"`
+	ClientMaxSeries int     `env:"CLIENT_MAX_SERIES" json:"client_max_series"`
+	ClientSeriesTTL int     `env:"CLIENT_SERIES_TTL" json:"client_series_ttl"`
+	MaxBatchSize    int     `env:"MAX_BATCH_SIZE" json:"max_batch_size"`
+	MaxBodySize     int64   `env:"MAX_BODY_SIZE" json:"max_body_size"`
 }
 
 const (
 	storeIntervalDefault = 300
 	serverAddressDefault = "localhost:8080"
 	filePathDefault      = "/tmp/metrics-db.json"
+	replayWindowDefault  = 300
+	maxBodySizeDefault   = 10 << 20
+	seriesTTLDefault     = 3600
 )
 
 func NewServerConfig() (*ServerConfig, error) {
 	cfg := ServerConfig{}
 	cfg.init()
 
-	if cfg.SignKey != "" {
-		cfg.SignKeyByte = []byte(cfg.SignKey)
-	}
-
 	if err := env.Parse(&cfg); err != nil {
 		logrus.Errorf("env parsing error: %v", err)
 		return nil, err
 	}
 
+	if cfg.SignKey != "" {
+		cfg.SignKeyByte = []byte(cfg.SignKey)
+	}
+
 	if cfg.ConfigPath != "" {
 		cfgJSON, err := readConfigFile(cfg.ConfigPath)
 		if err != nil {
@@ -60,7 +79,23 @@ func (c *ServerConfig) init() {
 	flag.BoolVar(&c.Restore, "r", true, "Restore")
 	flag.StringVar(&c.DatabaseDSN, "d", "", "Connect database string")
 	flag.StringVar(&c.SignKey, "k", "", "Server key")
-	flag.StringVar(&c.PrivateKey, "-crypto-key", "", "Private key path")
+	flag.StringVar(&c.PrivateKey, "crypto-key", "", "Private key paths separated by commas")
+	flag.IntVar(&c.ReplayWindow, "replay-window", replayWindowDefault, "Accepted age of signed requests in seconds, 0 checks only that nonces are not reused")
+	flag.StringVar(&c.TokensFile, "tokens", "", "Path to the API tokens file, enables token authentication")
+	flag.BoolVar(&c.TokensDB, "tokens-db", false, "Keep API tokens in the database, enables token authentication")
+	flag.StringVar(&c.AdminToken, "admin-token", "", "Bootstrap admin token, enables token authentication")
+	flag.Float64Var(&c.ClientRateLimit, "client-rate-limit", 0, "Write requests per second allowed to each client, 0 disables the limit")
+	flag.IntVar(&c.ClientRateBurst, "client-rate-burst", 0, "Write requests a client may send at once (default - the rate limit)")
+	flag.IntVar(&c.ClientMaxSeries, "client-max-series", 0, "Distinct series each client may write, 0 disables the quota")
+	flag.IntVar(&c.ClientSeriesTTL, "client-series-ttl", seriesTTLDefault, "Seconds after which a series the client stopped writing leaves its quota")
+	flag.IntVar(&c.MaxBatchSize, "max-batch-size", 0, "Metrics allowed in one batch update, 0 disables the limit")
+	flag.Int64Var(&c.MaxBodySize, "max-body-size", maxBodySizeDefault, "Request body limit in bytes, 0 disables the limit")
+	flag.StringVar(&c.TrustedSubnet, "t", "", "Trusted subnets in CIDR notation separated by commas")
+	flag.StringVar(&c.TrustedProxies, "trusted-proxies", "", "Proxies whose X-Forwarded-For header is honored, CIDRs separated by commas")
+	flag.BoolVar(&c.TrustRealIP, "trust-real-ip", false, "Honor the X-Real-IP header of any peer, not only of trusted proxies")
+	flag.StringVar(&c.TLSCertFile, "tls-cert", "", "Server certificate path, enables HTTPS and gRPC over TLS")
+	flag.StringVar(&c.TLSKeyFile, "tls-key", "", "Server certificate key path")
+	flag.StringVar(&c.TLSClientCAFile, "tls-client-ca", "", "CA certificate path to require and verify client certificates")
 	flag.StringVar(&c.ConfigPath, "c", "", "Path to config file")
 	flag.StringVar(&c.ConfigPath, "config", "", "Path to config file (the same as -c)")
 	flag.Parse()
@@ -80,17 +115,23 @@ func readConfigFile(path string) (cfg *ServerConfig, err error) {
 	return cfg, nil
 }
 
-func (c *ServerConfig) GetPrivateKey() (*rsa.PrivateKey, error) {
-	privateKeyPEM, err := os.ReadFile(c.PrivateKey)
-	if err != nil {
-		return nil, err
+// GetKeyring loads the private keys listed in PrivateKey, separated by commas. Listing
+// the new key next to the old one lets agents switch keys without downtime.
+func (c *ServerConfig) GetKeyring() (*keys.Keyring, error) {
+	var paths []string
+	for _, path := range strings.Split(c.PrivateKey, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
 	}
 
-	privateKeyBlock, _ := pem.Decode(privateKeyPEM)
-	privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
-	if err != nil {
-		return nil, err
-	}
+	return keys.LoadKeyring(paths...)
+}
+
+func (c *ServerConfig) GetTrustedSubnet() (subnet.Set, error) {
+	return subnet.Parse(c.TrustedSubnet)
+}
 
-	return privateKey, nil
+func (c *ServerConfig) GetTrustedProxies() (subnet.Set, error) {
+	return subnet.Parse(c.TrustedProxies)
 }
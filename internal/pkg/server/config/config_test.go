@@ -37,6 +37,9 @@ func TestNewServerConfig(t *testing.T) {
 				Restore:         true,
 				DatabaseDSN:     "",
 				SignKey:         "",
+				ReplayWindow:    300,
+				MaxBodySize:     10 << 20,
+				ClientSeriesTTL: 3600,
 			},
 		}, // TODO: Add test cases.
 	}
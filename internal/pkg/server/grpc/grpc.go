@@ -1,11 +1,25 @@
 package grpc
 
 import (
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	pb "github.com/mayr0y/animated-octo-couscous.git/api/server"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/watch"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
 )
 
 type MetricsServer struct {
@@ -13,7 +27,6 @@ type MetricsServer struct {
 }
 
 func (s *Server) UpdateMetrics(stream pb.Metrics_UpdateMetricsServer) error {
-	var metric metrics.Metrics
 	metricsSlice := make([]*metrics.Metrics, 0)
 	for {
 		message, err := stream.Recv()
@@ -24,27 +37,12 @@ func (s *Server) UpdateMetrics(stream pb.Metrics_UpdateMetricsServer) error {
 			return err
 		}
 
-		switch message.Metric.MType {
-		case metrics.GaugeMetricName:
-			gaugeValue := metrics.Gauge(message.Metric.Value)
-			metric = metrics.Metrics{
-				ID:    message.Metric.ID,
-				MType: message.Metric.MType,
-				Value: &gaugeValue,
-			}
-		case metrics.CounterMetricName:
-			counterValue := metrics.Counter(message.Metric.Delta)
-			metric = metrics.Metrics{
-				ID:    message.Metric.ID,
-				MType: message.Metric.MType,
-				Delta: &counterValue,
-			}
-		default:
-			err := fmt.Errorf("unknown metric type: %s", message.Metric.MType)
+		metric, err := fromProto(message.Metric)
+		if err != nil {
 			return stream.SendAndClose(&pb.UpdateMetricResponse{Error: err.Error()})
 		}
 
-		metricsSlice = append(metricsSlice, &metric)
+		metricsSlice = append(metricsSlice, metric)
 	}
 
 	if err := s.metricsStore.UpdateMetrics(stream.Context(), metricsSlice); err != nil {
@@ -53,3 +51,239 @@ func (s *Server) UpdateMetrics(stream pb.Metrics_UpdateMetricsServer) error {
 
 	return stream.SendAndClose(&pb.UpdateMetricResponse{Error: "Metrics are updated"})
 }
+
+func (s *Server) UpdateMetric(ctx context.Context, req *pb.UpdateMetricRequest) (*pb.Metric, error) {
+	metric, err := fromProto(req.GetMetric())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err = s.metricsStore.UpdateMetrics(ctx, []*metrics.Metrics{metric}); err != nil {
+		return nil, storeError(err)
+	}
+
+	updated, ok := s.metricsStore.GetMetric(ctx, metric.ID, metric.MType)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "metric %s is not found after update", metric.ID)
+	}
+
+	return toProto(updated), nil
+}
+
+func (s *Server) UpdateMetricsBatch(
+	ctx context.Context,
+	req *pb.UpdateMetricsBatchRequest,
+) (*pb.UpdateMetricsBatchResponse, error) {
+	metricsSlice := make([]*metrics.Metrics, 0, len(req.GetMetrics()))
+	for i, m := range req.GetMetrics() {
+		metric, err := fromProto(m)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "metric #%d: %v", i, err)
+		}
+		metricsSlice = append(metricsSlice, metric)
+	}
+
+	if err := s.metricsStore.UpdateMetrics(ctx, metricsSlice); err != nil {
+		return nil, storeError(err)
+	}
+
+	return &pb.UpdateMetricsBatchResponse{}, nil
+}
+
+func (s *Server) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.Metric, error) {
+	if req.GetID() == "" {
+		return nil, status.Error(codes.InvalidArgument, "metric ID is required")
+	}
+	if !validMetricType(req.GetMType()) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown metric type: %s", req.GetMType())
+	}
+
+	metric, ok := s.metricsStore.GetMetric(ctx, req.GetID(), req.GetMType())
+	if !ok || metric.MType != req.GetMType() {
+		return nil, status.Errorf(codes.NotFound, "metric %s:%s is not found", req.GetMType(), req.GetID())
+	}
+
+	return toProto(metric), nil
+}
+
+func (s *Server) ListMetrics(ctx context.Context, req *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	page, nextPageToken, err := listMetrics(ctx, s.metricsStore, req.GetPageSize(), req.GetPageToken(), req.GetPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListMetricsResponse{
+		Metrics:       make([]*pb.Metric, 0, len(page)),
+		NextPageToken: nextPageToken,
+	}
+	for _, m := range page {
+		resp.Metrics = append(resp.Metrics, toProto(m))
+	}
+
+	return resp, nil
+}
+
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.Metrics_WatchServer) error {
+	return watchMetrics(stream.Context(), s.Hub, req.GetTypes(), req.GetNamePattern(), func(m *metrics.Metrics) error {
+		return stream.Send(toProto(m))
+	})
+}
+
+// listMetrics returns one page of metrics ordered by ID, the page token is the encoded last ID of the previous page.
+func listMetrics(
+	ctx context.Context,
+	store storage.Store,
+	requestedPageSize int32,
+	pageToken string,
+	prefix string,
+) ([]*metrics.Metrics, string, error) {
+	pageSize := int(requestedPageSize)
+	switch {
+	case pageSize < 0:
+		return nil, "", status.Error(codes.InvalidArgument, "page size must not be negative")
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	after, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, "", status.Errorf(codes.InvalidArgument, "invalid page token: %v", err)
+	}
+
+	metricsMap, err := store.GetMetrics(ctx)
+	if err != nil {
+		return nil, "", status.Errorf(codes.Internal, "get metrics: %v", err)
+	}
+
+	ids := make([]string, 0, len(metricsMap))
+	for id := range metricsMap {
+		if strings.HasPrefix(id, prefix) && id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var nextPageToken string
+	if len(ids) > pageSize {
+		ids = ids[:pageSize]
+		nextPageToken = encodePageToken(ids[len(ids)-1])
+	}
+
+	page := make([]*metrics.Metrics, 0, len(ids))
+	for _, id := range ids {
+		page = append(page, metricsMap[id])
+	}
+
+	return page, nextPageToken, nil
+}
+
+func watchMetrics(
+	ctx context.Context,
+	hub *watch.Hub,
+	types []string,
+	namePattern string,
+	send func(m *metrics.Metrics) error,
+) error {
+	if hub == nil {
+		return status.Error(codes.Unimplemented, "watch is not enabled")
+	}
+
+	sub, err := hub.Subscribe(watch.Filter{
+		Types:       types,
+		NamePattern: namePattern,
+	})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer hub.Unsubscribe(sub)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case metric, ok := <-sub.Updates():
+			if !ok {
+				return watchError(sub.Err())
+			}
+			if !auth.AllowsMetric(ctx, metric.ID) {
+				continue
+			}
+			if err = send(metric); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func watchError(err error) error {
+	switch {
+	case errors.Is(err, watch.ErrSlowConsumer):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, watch.ErrHubClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return nil
+	}
+}
+
+func fromProto(m *pb.Metric) (*metrics.Metrics, error) {
+	if m == nil {
+		return nil, errors.New("metric is required")
+	}
+	if m.ID == "" {
+		return nil, errors.New("metric ID is required")
+	}
+
+	switch m.MType {
+	case metrics.GaugeMetricName:
+		gaugeValue := metrics.Gauge(m.Value)
+		return &metrics.Metrics{
+			ID:    m.ID,
+			MType: m.MType,
+			Value: &gaugeValue,
+		}, nil
+	case metrics.CounterMetricName:
+		counterValue := metrics.Counter(m.Delta)
+		return &metrics.Metrics{
+			ID:    m.ID,
+			MType: m.MType,
+			Delta: &counterValue,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown metric type: %s", m.MType)
+	}
+}
+
+func toProto(m *metrics.Metrics) *pb.Metric {
+	metric := &pb.Metric{
+		ID:    m.ID,
+		MType: m.MType,
+	}
+	if m.Value != nil {
+		metric.Value = float32(*m.Value)
+	}
+	if m.Delta != nil {
+		metric.Delta = int64(*m.Delta)
+	}
+
+	return metric
+}
+
+func validMetricType(mType string) bool {
+	return mType == metrics.GaugeMetricName || mType == metrics.CounterMetricName
+}
+
+func encodePageToken(lastID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(lastID))
+}
+
+func decodePageToken(token string) (string, error) {
+	lastID, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", err
+	}
+
+	return string(lastID), nil
+}
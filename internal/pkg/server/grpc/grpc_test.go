@@ -0,0 +1,61 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/mayr0y/animated-octo-couscous.git/api/server"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer_ReadAPI(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{metricsStore: storage.NewMetrics()}
+
+	_, err := s.UpdateMetricsBatch(ctx, &pb.UpdateMetricsBatchRequest{Metrics: []*pb.Metric{
+		{ID: "Alloc", MType: metrics.GaugeMetricName, Value: 1.5},
+		{ID: "HeapAlloc", MType: metrics.GaugeMetricName, Value: 2},
+		{ID: "HeapIdle", MType: metrics.GaugeMetricName, Value: 3},
+		{ID: "PollCount", MType: metrics.CounterMetricName, Delta: 2},
+	}})
+	require.NoError(t, err)
+
+	updated, err := s.UpdateMetric(ctx, &pb.UpdateMetricRequest{
+		Metric: &pb.Metric{ID: "PollCount", MType: metrics.CounterMetricName, Delta: 3},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), updated.Delta)
+
+	got, err := s.GetMetric(ctx, &pb.GetMetricRequest{ID: "Alloc", MType: metrics.GaugeMetricName})
+	require.NoError(t, err)
+	assert.Equal(t, float32(1.5), got.Value)
+
+	_, err = s.GetMetric(ctx, &pb.GetMetricRequest{ID: "Alloc", MType: metrics.CounterMetricName})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.GetMetric(ctx, &pb.GetMetricRequest{ID: "Alloc", MType: "histogram"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pb.Metric{ID: "Alloc"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	page, err := s.ListMetrics(ctx, &pb.ListMetricsRequest{PageSize: 1, Prefix: "Heap"})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 1)
+	assert.Equal(t, "HeapAlloc", page.Metrics[0].ID)
+	require.NotEmpty(t, page.NextPageToken)
+
+	page, err = s.ListMetrics(ctx, &pb.ListMetricsRequest{PageSize: 1, Prefix: "Heap", PageToken: page.NextPageToken})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 1)
+	assert.Equal(t, "HeapIdle", page.Metrics[0].ID)
+	assert.Empty(t, page.NextPageToken)
+
+	_, err = s.ListMetrics(ctx, &pb.ListMetricsRequest{PageToken: "%%%"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,444 @@
+package grpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"path"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/certs"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/ratelimit"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
+	"github.com/sirupsen/logrus"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	HashMetadataKey          = "hashsha256"
+	TimestampMetadataKey     = "x-timestamp"
+	NonceMetadataKey         = "x-nonce"
+	RealIPMetadataKey        = "x-real-ip"
+	ForwardedForMetadataKey  = "x-forwarded-for"
+	AuthorizationMetadataKey = "authorization"
+)
+
+func LoggingUnaryInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logCall(ctx, info.FullMethod, start, err)
+
+	return resp, err
+}
+
+func LoggingStreamInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	logCall(ss.Context(), info.FullMethod, start, err)
+
+	return err
+}
+
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	if identity := certs.PeerIdentity(ctx); identity != "" {
+		addr = identity + "@" + addr
+	}
+
+	logrus.Infof("gRPC call %s from %s, code %s, duration %s", method, addr, status.Code(err), time.Since(start))
+}
+
+// SubnetUnaryInterceptor rejects writes whose client address is outside the trusted
+// networks. The address is resolved from the x-real-ip and x-forwarded-for metadata
+// and the peer address, like for HTTP requests.
+func SubnetUnaryInterceptor(trusted subnet.Set, resolver subnet.Resolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkSubnet(ctx, info.FullMethod, trusted, resolver); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func SubnetStreamInterceptor(trusted subnet.Set, resolver subnet.Resolver) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkSubnet(ss.Context(), info.FullMethod, trusted, resolver); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+func checkSubnet(ctx context.Context, fullMethod string, trusted subnet.Set, resolver subnet.Resolver) error {
+	if len(trusted) == 0 || methodScope(fullMethod) != auth.ScopeWrite {
+		return nil
+	}
+
+	ip := clientIP(ctx, resolver)
+	if ip == nil {
+		return status.Error(codes.PermissionDenied, "client address is unknown")
+	}
+	if !trusted.Contains(ip) {
+		return status.Errorf(codes.PermissionDenied, "address %s is not in trusted subnet", ip)
+	}
+
+	return nil
+}
+
+func clientIP(ctx context.Context, resolver subnet.Resolver) net.IP {
+	var peerIP net.IP
+	if p, ok := peer.FromContext(ctx); ok {
+		peerIP = subnet.HostIP(p.Addr.String())
+	}
+
+	var realIP string
+	var forwardedFor []string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RealIPMetadataKey); len(values) > 0 {
+			realIP = values[0]
+		}
+		forwardedFor = md.Get(ForwardedForMetadataKey)
+	}
+
+	return resolver.ClientIP(peerIP, realIP, forwardedFor)
+}
+
+// AuthUnaryInterceptor requires a bearer token in the authorization metadata with the
+// scope of the called method and puts the token into the context.
+func AuthUnaryInterceptor(a *auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if a == nil {
+			return handler(ctx, req)
+		}
+
+		ctx, err := authorize(ctx, a, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func AuthStreamInterceptor(a *auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if a == nil {
+			return handler(srv, ss)
+		}
+
+		ctx, err := authorize(ss.Context(), a, info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func authorize(ctx context.Context, a *auth.Authenticator, fullMethod string) (context.Context, error) {
+	var secret string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(AuthorizationMetadataKey); len(values) > 0 {
+			secret = auth.BearerToken(values[0])
+		}
+	}
+
+	token, err := a.Authorize(ctx, secret, methodScope(fullMethod))
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, auth.ErrForbidden):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return auth.WithToken(ctx, token), nil
+}
+
+func methodScope(fullMethod string) auth.Scope {
+	switch path.Base(fullMethod) {
+	case "GetMetric", "ListMetrics", "Watch":
+		return auth.ScopeRead
+	default:
+		return auth.ScopeWrite
+	}
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// RateLimitUnaryInterceptor identifies the client of write calls for the ratelimit.Store
+// quotas and charges its bucket. A nil limiter only identifies clients.
+func RateLimitUnaryInterceptor(limiter *ratelimit.Limiter, resolver subnet.Resolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if methodScope(info.FullMethod) != auth.ScopeWrite {
+			return handler(ctx, req)
+		}
+
+		client := ratelimit.PeerClient(ctx, clientIP(ctx, resolver).String())
+		if err := allow(limiter, client); err != nil {
+			return nil, err
+		}
+
+		return handler(ratelimit.WithClient(ctx, client), req)
+	}
+}
+
+// RateLimitStreamInterceptor charges the bucket for every message of a client stream.
+func RateLimitStreamInterceptor(limiter *ratelimit.Limiter, resolver subnet.Resolver) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if methodScope(info.FullMethod) != auth.ScopeWrite {
+			return handler(srv, ss)
+		}
+
+		ctx := ss.Context()
+		client := ratelimit.PeerClient(ctx, clientIP(ctx, resolver).String())
+
+		return handler(srv, &limitedStream{
+			ServerStream: ss,
+			ctx:          ratelimit.WithClient(ctx, client),
+			limiter:      limiter,
+			client:       client,
+		})
+	}
+}
+
+func allow(limiter *ratelimit.Limiter, client string) error {
+	if limiter == nil {
+		return nil
+	}
+
+	ok, wait := limiter.Allow(client, time.Now())
+	if ok {
+		return nil
+	}
+
+	st, err := status.New(codes.ResourceExhausted, "rate limit exceeded").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+
+	return st.Err()
+}
+
+type limitedStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	limiter *ratelimit.Limiter
+	client  string
+}
+
+func (s *limitedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *limitedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	return allow(s.limiter, s.client)
+}
+
+// SignUnaryInterceptor verifies the hashsha256 metadata, an HMAC-SHA256 over the
+// x-timestamp and x-nonce metadata and the request as sign.MessagePart. With a replay
+// guard, calls outside its window or reusing a nonce are rejected. OTLP exports are
+// not signed, see unsigned.
+func SignUnaryInterceptor(key []byte, replay *sign.ReplayGuard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if key == nil || unsigned(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		expected, timestamp, nonce, err := callSignature(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		payload, err := sign.MessagePart(req)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		h := sign.NewMessagesHash(key, timestamp, nonce)
+		h.Write(payload)
+		if !hmac.Equal(expected, h.Sum(nil)) {
+			return nil, status.Error(codes.Unauthenticated, "invalid hashsha256 signature")
+		}
+		if err = checkReplay(replay, timestamp, nonce); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// SignStreamInterceptor verifies the hashsha256 metadata of a stream. For client streams
+// the HMAC covers all received messages in order and is checked when the client closes
+// its side, before the handler sees io.EOF; the replay guard is consulted then as well.
+func SignStreamInterceptor(key []byte, replay *sign.ReplayGuard) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if key == nil || unsigned(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		expected, timestamp, nonce, err := callSignature(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &signedStream{
+			ServerStream: ss,
+			expected:     expected,
+			hash:         sign.NewMessagesHash(key, timestamp, nonce),
+			clientStream: info.IsClientStream,
+			replay:       replay,
+			timestamp:    timestamp,
+			nonce:        nonce,
+		})
+	}
+}
+
+// unsigned reports whether calls of the method are exempt from signing. OTLP exporters
+// know nothing about the shared key, their exports rely on the trusted subnet, tokens
+// and TLS instead.
+func unsigned(fullMethod string) bool {
+	return path.Dir(fullMethod) == "/"+collectormetrics.MetricsService_ServiceDesc.ServiceName
+}
+
+// callSignature returns the decoded hashsha256 metadata of a call with the timestamp
+// and nonce it covers.
+func callSignature(ctx context.Context) (signature []byte, timestamp, nonce string, err error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, "", "", status.Error(codes.Unauthenticated, "hashsha256 metadata is required")
+	}
+
+	values := md.Get(HashMetadataKey)
+	if len(values) == 0 || values[0] == "" {
+		return nil, "", "", status.Error(codes.Unauthenticated, "hashsha256 metadata is required")
+	}
+	signature, err = hex.DecodeString(values[0])
+	if err != nil {
+		return nil, "", "", status.Error(codes.Unauthenticated, "invalid hashsha256 signature")
+	}
+
+	timestamp, nonce = firstValue(md, TimestampMetadataKey), firstValue(md, NonceMetadataKey)
+	if timestamp == "" && nonce == "" {
+		return nil, "", "", status.Error(codes.Unauthenticated, sign.ErrLegacySignature.Error())
+	}
+
+	return signature, timestamp, nonce, nil
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// checkReplay consults the replay guard once the signature of a call is verified.
+func checkReplay(replay *sign.ReplayGuard, timestamp, nonce string) error {
+	if replay == nil {
+		return nil
+	}
+
+	err := replay.Check(timestamp, nonce, time.Now())
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sign.ErrReplayedRequest):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, sign.ErrStaleRequest):
+		return status.Error(codes.Unauthenticated, err.Error())
+	default:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+}
+
+type signedStream struct {
+	grpc.ServerStream
+	expected     []byte
+	hash         hash.Hash
+	clientStream bool
+	verified     bool
+	replay       *sign.ReplayGuard
+	timestamp    string
+	nonce        string
+}
+
+func (s *signedStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if errors.Is(err, io.EOF) {
+		return s.verify(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	payload, err := sign.MessagePart(m)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	s.hash.Write(payload)
+
+	if !s.clientStream {
+		return s.verify(nil)
+	}
+
+	return nil
+}
+
+func (s *signedStream) verify(result error) error {
+	if s.verified {
+		return result
+	}
+	s.verified = true
+
+	if !hmac.Equal(s.expected, s.hash.Sum(nil)) {
+		return status.Error(codes.Unauthenticated, "invalid hashsha256 signature")
+	}
+	if err := checkReplay(s.replay, s.timestamp, s.nonce); err != nil {
+		return err
+	}
+
+	return result
+}
@@ -0,0 +1,268 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pbv2 "github.com/mayr0y/animated-octo-couscous.git/api/serverv2"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/ratelimit"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1 << 20
+
+func startTestServer(t *testing.T, s *Server) pbv2.MetricsClient {
+	t.Helper()
+
+	return pbv2.NewMetricsClient(startTestConn(t, s))
+}
+
+func startTestConn(t *testing.T, s *Server) *grpc.ClientConn {
+	t.Helper()
+
+	listener := bufconn.Listen(bufSize)
+	grpcServer := s.newGRPCServer(auth.NewStore(storage.NewMetrics()))
+	go func() { _ = grpcServer.Serve(listener) }()
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestSignInterceptors(t *testing.T) {
+	key := []byte("secret")
+	client := startTestServer(t, &Server{SignKey: key, Replay: sign.NewReplayGuard(time.Minute, 100)})
+
+	signedContext := func(signature, timestamp, nonce string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(),
+			HashMetadataKey, signature, TimestampMetadataKey, timestamp, NonceMetadataKey, nonce)
+	}
+	now := sign.Timestamp(time.Now())
+
+	req := &pbv2.UpdateMetricsBatchRequest{Metrics: []*pbv2.Metric{
+		{Id: "Alloc", Type: metrics.GaugeMetricName, Value: 1},
+	}}
+	signature, err := sign.SumMessages(key, now, "n1", req)
+	require.NoError(t, err)
+
+	_, err = client.UpdateMetricsBatch(signedContext(signature, now, "n1"), req)
+	require.NoError(t, err)
+
+	// A captured call can't be sent again, nor with fresh metadata.
+	_, err = client.UpdateMetricsBatch(signedContext(signature, now, "n1"), req)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = client.UpdateMetricsBatch(signedContext(signature, now, "n2"), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stale := sign.Timestamp(time.Now().Add(-time.Hour))
+	staleSignature, err := sign.SumMessages(key, stale, "n3", req)
+	require.NoError(t, err)
+	_, err = client.UpdateMetricsBatch(signedContext(staleSignature, stale, "n3"), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.UpdateMetricsBatch(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	legacySignature, err := sign.SumMessages(key, "", "", req)
+	require.NoError(t, err)
+	_, err = client.UpdateMetricsBatch(
+		metadata.AppendToOutgoingContext(context.Background(), HashMetadataKey, legacySignature), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.UpdateMetricsBatch(signedContext(sign.Sum([]byte("other")), now, "n4"), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	messages := []*pbv2.UpdateMetricRequest{
+		{Metric: &pbv2.Metric{Id: "PollCount", Type: metrics.CounterMetricName, Delta: 1}},
+		{Metric: &pbv2.Metric{Id: "PollCount", Type: metrics.CounterMetricName, Delta: 2}},
+	}
+	streamSignature, err := sign.SumMessages(key, now, "s1", messages[0], messages[1])
+	require.NoError(t, err)
+	singleSignature, err := sign.SumMessages(key, now, "s2", messages[0])
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name      string
+		signature string
+		nonce     string
+		want      codes.Code
+	}{
+		{name: "valid stream signature", signature: streamSignature, nonce: "s1", want: codes.OK},
+		{name: "replayed stream", signature: streamSignature, nonce: "s1", want: codes.AlreadyExists},
+		{name: "signature of a single message", signature: singleSignature, nonce: "s2", want: codes.Unauthenticated},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := client.UpdateMetrics(signedContext(tt.signature, now, tt.nonce))
+			require.NoError(t, err)
+			for _, m := range messages {
+				require.NoError(t, stream.Send(m))
+			}
+			_, err = stream.CloseAndRecv()
+			assert.Equal(t, tt.want, status.Code(err))
+		})
+	}
+}
+
+func TestSignInterceptorsSkipOTLP(t *testing.T) {
+	client := collectormetrics.NewMetricsServiceClient(startTestConn(t, &Server{SignKey: []byte("secret")}))
+
+	_, err := client.Export(context.Background(), &collectormetrics.ExportMetricsServiceRequest{})
+	assert.NoError(t, err)
+}
+
+func TestSubnetInterceptors(t *testing.T) {
+	trusted, err := subnet.Parse("192.168.1.0/24, 10.1.0.0/16")
+	require.NoError(t, err)
+	client := startTestServer(t, &Server{TrustedSubnet: trusted, Resolver: subnet.Resolver{TrustRealIP: true}})
+
+	update := &pbv2.UpdateMetricsBatchRequest{Metrics: []*pbv2.Metric{
+		{Id: "Alloc", Type: metrics.GaugeMetricName, Value: 1},
+	}}
+	fromIP := func(ip string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), RealIPMetadataKey, ip)
+	}
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{name: "first subnet", ctx: fromIP("192.168.1.10"), want: codes.OK},
+		{name: "second subnet", ctx: fromIP("10.1.2.3"), want: codes.OK},
+		{name: "outside", ctx: fromIP("172.16.0.1"), want: codes.PermissionDenied},
+		{name: "unknown address", ctx: context.Background(), want: codes.PermissionDenied},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.UpdateMetricsBatch(tt.ctx, update)
+			assert.Equal(t, tt.want, status.Code(err))
+		})
+	}
+
+	// Reads are not restricted.
+	_, err = client.GetMetric(fromIP("172.16.0.1"), &pbv2.GetMetricRequest{Id: "Alloc", Type: metrics.GaugeMetricName})
+	assert.NoError(t, err)
+}
+
+func TestSubnetInterceptorsIgnoreUntrustedRealIP(t *testing.T) {
+	trusted, err := subnet.Parse("192.168.1.0/24")
+	require.NoError(t, err)
+	client := startTestServer(t, &Server{TrustedSubnet: trusted})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), RealIPMetadataKey, "192.168.1.10")
+	_, err = client.UpdateMetricsBatch(ctx, &pbv2.UpdateMetricsBatchRequest{Metrics: []*pbv2.Metric{
+		{Id: "Alloc", Type: metrics.GaugeMetricName, Value: 1},
+	}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAuthInterceptors(t *testing.T) {
+	tokens := &staticTokens{tokens: map[string]*auth.Token{
+		auth.HashSecret("writer"): {ID: "writer", Scopes: []auth.Scope{auth.ScopeWrite}, Prefixes: []string{"app."}},
+		auth.HashSecret("reader"): {ID: "reader", Scopes: []auth.Scope{auth.ScopeRead}},
+	}}
+	client := startTestServer(t, &Server{Auth: auth.NewAuthenticator(tokens, "")})
+
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), AuthorizationMetadataKey, "Bearer "+token)
+	}
+	update := func(ctx context.Context, id string) (*pbv2.UpdateMetricsResponse, error) {
+		return client.UpdateMetricsBatch(ctx, &pbv2.UpdateMetricsBatchRequest{Metrics: []*pbv2.Metric{
+			{Id: id, Type: metrics.GaugeMetricName, Value: 1},
+		}})
+	}
+
+	_, err := update(context.Background(), "app.Alloc")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = update(withToken("reader"), "app.Alloc")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	resp, err := update(withToken("writer"), "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, int32(codes.PermissionDenied), resp.GetResults()[0].GetStatus().GetCode())
+
+	resp, err = update(withToken("writer"), "app.Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), resp.GetResults()[0].GetStatus().GetCode())
+
+	_, err = client.GetMetric(withToken("reader"), &pbv2.GetMetricRequest{Id: "app.Alloc", Type: metrics.GaugeMetricName})
+	assert.NoError(t, err)
+}
+
+func TestRateLimitInterceptors(t *testing.T) {
+	client := startTestServer(t, &Server{Limiter: ratelimit.NewLimiter(0.5, 2), Resolver: subnet.Resolver{TrustRealIP: true}})
+
+	fromIP := func(ip string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), RealIPMetadataKey, ip)
+	}
+	update := &pbv2.UpdateMetricsBatchRequest{Metrics: []*pbv2.Metric{
+		{Id: "Alloc", Type: metrics.GaugeMetricName, Value: 1},
+	}}
+
+	for i := 0; i < 2; i++ {
+		_, err := client.UpdateMetricsBatch(fromIP("10.0.0.1"), update)
+		require.NoError(t, err)
+	}
+
+	_, err := client.UpdateMetricsBatch(fromIP("10.0.0.1"), update)
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1)
+	retry, ok := st.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	assert.Positive(t, retry.GetRetryDelay().AsDuration())
+
+	// Reads and other clients are not affected.
+	_, err = client.GetMetric(fromIP("10.0.0.1"), &pbv2.GetMetricRequest{Id: "Alloc", Type: metrics.GaugeMetricName})
+	assert.NoError(t, err)
+
+	// Streams are charged per message.
+	stream, err := client.UpdateMetrics(fromIP("10.0.0.2"))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&pbv2.UpdateMetricRequest{Metric: update.Metrics[0]}); err != nil {
+			break
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+type staticTokens struct {
+	tokens map[string]*auth.Token
+}
+
+func (s *staticTokens) Lookup(_ context.Context, hash string) (*auth.Token, error) {
+	if t, ok := s.tokens[hash]; ok {
+		return t, nil
+	}
+	return nil, auth.ErrTokenNotFound
+}
+
+func (s *staticTokens) Create(context.Context, *auth.Token) error {
+	return nil
+}
@@ -0,0 +1,502 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/sirupsen/logrus"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+const (
+	histogramCountSuffix  = "_count"
+	histogramSumSuffix    = "_sum"
+	histogramBucketSuffix = "_bucket"
+	bucketBoundLabel      = "le"
+	bucketInfBound        = "+Inf"
+)
+
+// OTLPServer accepts OpenTelemetry metrics exports and stores them as gauges and counters.
+//
+// The metric model has no labels, so resource and data point attributes with scalar
+// values are folded into the metric ID as name{key="value",...}, see formatSeriesID.
+// Cumulative sums are
+// converted into counter deltas using the last value seen for the same series; a
+// series not exported for cumulativeTTL starts over.
+type OTLPServer struct {
+	metricsStore storage.Store
+	// lock is held over the whole export, so the deltas of concurrent exports of a
+	// series never start from the same value.
+	lock       sync.Mutex
+	cumulative map[string]cumulativePoint
+	lastSweep  time.Time
+	collectormetrics.UnimplementedMetricsServiceServer
+}
+
+const (
+	cumulativeTTL           = time.Hour
+	cumulativeSweepInterval = time.Minute
+)
+
+type cumulativePoint struct {
+	start uint64
+	value float64
+	seen  time.Time
+}
+
+func NewOTLPServer(s storage.Store) *OTLPServer {
+	return &OTLPServer{
+		metricsStore: s,
+		cumulative:   make(map[string]cumulativePoint),
+	}
+}
+
+func (s *OTLPServer) Export(
+	ctx context.Context,
+	req *collectormetrics.ExportMetricsServiceRequest,
+) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	b := &otlpBatch{committed: s.cumulative, staged: make(map[string]cumulativePoint), now: now}
+	b.translate(req.GetResourceMetrics())
+
+	if err := s.apply(ctx, b); err != nil {
+		logrus.Errorf("Error update OTLP metrics: %v", err)
+		return nil, storeError(err)
+	}
+
+	resp := &collectormetrics.ExportMetricsServiceResponse{}
+	if b.rejected > 0 {
+		resp.PartialSuccess = &collectormetrics.ExportMetricsPartialSuccess{
+			RejectedDataPoints: b.rejected,
+			ErrorMessage:       strings.Join(b.reasons, "; "),
+		}
+	}
+
+	return resp, nil
+}
+
+// apply stores the batch and keeps the cumulative state of the stored metrics. Stores
+// apply a batch atomically, so when a type conflict refuses it, it is retried metric by
+// metric and the data points of the refused metrics are rejected.
+func (s *OTLPServer) apply(ctx context.Context, b *otlpBatch) error {
+	if len(b.metrics) == 0 {
+		return nil
+	}
+
+	err := s.metricsStore.UpdateMetrics(ctx, b.metrics)
+	if err == nil {
+		b.commit(nil)
+		return nil
+	}
+	if !errors.Is(err, storage.ErrMetricTypeMismatch) {
+		return err
+	}
+
+	failed := make(map[string]bool)
+	rejectedPoints := make(map[int]bool)
+	for i, m := range b.metrics {
+		if err = s.metricsStore.UpdateMetrics(ctx, []*metrics.Metrics{m}); err == nil {
+			continue
+		}
+		if !errors.Is(err, storage.ErrMetricTypeMismatch) {
+			return err
+		}
+		failed[m.ID] = true
+		if !rejectedPoints[b.points[i]] {
+			rejectedPoints[b.points[i]] = true
+			b.reject(m.ID, 1, err.Error())
+		}
+	}
+	b.commit(failed)
+
+	return nil
+}
+
+// sweep forgets the cumulative series that were not exported for cumulativeTTL.
+func (s *OTLPServer) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < cumulativeSweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for id, p := range s.cumulative {
+		if now.Sub(p.seen) > cumulativeTTL {
+			delete(s.cumulative, id)
+		}
+	}
+}
+
+// otlpBatch is the translation of one export. Changes of the cumulative state are
+// staged in it until the metrics are stored.
+type otlpBatch struct {
+	metrics []*metrics.Metrics
+	// points holds the data point every metric comes from.
+	points []int
+	point  int
+
+	committed map[string]cumulativePoint
+	staged    map[string]cumulativePoint
+	now       time.Time
+
+	rejected int64
+	reasons  []string
+}
+
+func (b *otlpBatch) add(m ...*metrics.Metrics) {
+	for range m {
+		b.points = append(b.points, b.point)
+	}
+	b.metrics = append(b.metrics, m...)
+	b.point++
+}
+
+func (b *otlpBatch) reject(name string, count int, reason string) {
+	b.rejected += int64(count)
+	b.reasons = append(b.reasons, fmt.Sprintf("%s: %s", name, reason))
+}
+
+func (b *otlpBatch) last(id string) (cumulativePoint, bool) {
+	if p, ok := b.staged[id]; ok {
+		return p, true
+	}
+	p, ok := b.committed[id]
+	return p, ok
+}
+
+func (b *otlpBatch) set(id string, p cumulativePoint) {
+	p.seen = b.now
+	b.staged[id] = p
+}
+
+// commit makes the staged state of the series that are not failed the server state.
+func (b *otlpBatch) commit(failed map[string]bool) {
+	for id, p := range b.staged {
+		if !failed[id] {
+			b.committed[id] = p
+		}
+	}
+}
+
+func (b *otlpBatch) translate(resourceMetrics []*metricspb.ResourceMetrics) {
+	for _, rm := range resourceMetrics {
+		resourceLabels := attributesToLabels(rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				name := m.GetName()
+				if name == "" {
+					b.reject("<unnamed>", dataPointCount(m), "metric name is empty")
+					continue
+				}
+
+				switch data := m.GetData().(type) {
+				case *metricspb.Metric_Gauge:
+					for _, dp := range data.Gauge.GetDataPoints() {
+						id := seriesID(name, resourceLabels, dp.GetAttributes())
+						b.add(newGauge(id, numberValue(dp)))
+					}
+				case *metricspb.Metric_Sum:
+					temporality := data.Sum.GetAggregationTemporality()
+					if temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED {
+						b.reject(name, len(data.Sum.GetDataPoints()), "aggregation temporality is unspecified")
+						continue
+					}
+					for _, dp := range data.Sum.GetDataPoints() {
+						id := seriesID(name, resourceLabels, dp.GetAttributes())
+						b.add(b.sumToMetric(id, dp, temporality, data.Sum.GetIsMonotonic()))
+					}
+				case *metricspb.Metric_Histogram:
+					temporality := data.Histogram.GetAggregationTemporality()
+					if temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED {
+						b.reject(name, len(data.Histogram.GetDataPoints()), "aggregation temporality is unspecified")
+						continue
+					}
+					for _, dp := range data.Histogram.GetDataPoints() {
+						b.add(b.histogramToMetrics(name, resourceLabels, dp, temporality)...)
+					}
+				case *metricspb.Metric_ExponentialHistogram:
+					temporality := data.ExponentialHistogram.GetAggregationTemporality()
+					if temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED {
+						b.reject(name, len(data.ExponentialHistogram.GetDataPoints()), "aggregation temporality is unspecified")
+						continue
+					}
+					for _, dp := range data.ExponentialHistogram.GetDataPoints() {
+						b.add(b.histogramTotals(name, resourceLabels, dp.GetAttributes(),
+							dp.GetStartTimeUnixNano(), dp.GetCount(), dp.GetSum(), temporality)...)
+					}
+				default:
+					b.reject(name, dataPointCount(m), "unsupported metric data type")
+				}
+			}
+		}
+	}
+}
+
+func (b *otlpBatch) sumToMetric(
+	id string,
+	dp *metricspb.NumberDataPoint,
+	temporality metricspb.AggregationTemporality,
+	monotonic bool,
+) *metrics.Metrics {
+	cumulative := temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+
+	intValue, isInt := dp.GetValue().(*metricspb.NumberDataPoint_AsInt)
+	if !isInt {
+		// Counters are integral, so fractional sums are stored as a gauge holding the running total.
+		value := dp.GetAsDouble()
+		if !cumulative {
+			prev, _ := b.last(id)
+			value += prev.value
+			b.set(id, cumulativePoint{value: value})
+		}
+		return newGauge(id, value)
+	}
+
+	if !cumulative {
+		return newCounter(id, intValue.AsInt)
+	}
+
+	if !monotonic {
+		return newGauge(id, float64(intValue.AsInt))
+	}
+
+	return newCounter(id, b.delta(id, dp.GetStartTimeUnixNano(), intValue.AsInt))
+}
+
+func (b *otlpBatch) histogramToMetrics(
+	name string,
+	resourceLabels map[string]string,
+	dp *metricspb.HistogramDataPoint,
+	temporality metricspb.AggregationTemporality,
+) []*metrics.Metrics {
+	result := b.histogramTotals(name, resourceLabels, dp.GetAttributes(),
+		dp.GetStartTimeUnixNano(), dp.GetCount(), dp.GetSum(), temporality)
+
+	bounds := dp.GetExplicitBounds()
+	var cumulativeCount uint64
+	for i, count := range dp.GetBucketCounts() {
+		cumulativeCount += count
+
+		bound := bucketInfBound
+		if i < len(bounds) {
+			bound = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		}
+
+		labels := mergeLabels(resourceLabels, attributesToLabels(dp.GetAttributes()))
+		labels[bucketBoundLabel] = bound
+		id := formatSeriesID(name+histogramBucketSuffix, labels)
+
+		result = append(result, b.counterFromTotal(id, dp.GetStartTimeUnixNano(), int64(cumulativeCount), temporality))
+	}
+
+	return result
+}
+
+func (b *otlpBatch) histogramTotals(
+	name string,
+	resourceLabels map[string]string,
+	attributes []*commonpb.KeyValue,
+	start uint64,
+	count uint64,
+	sum float64,
+	temporality metricspb.AggregationTemporality,
+) []*metrics.Metrics {
+	countID := seriesID(name+histogramCountSuffix, resourceLabels, attributes)
+	sumID := seriesID(name+histogramSumSuffix, resourceLabels, attributes)
+
+	if temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		prev, _ := b.last(sumID)
+		sum += prev.value
+		b.set(sumID, cumulativePoint{value: sum})
+	}
+
+	return []*metrics.Metrics{
+		b.counterFromTotal(countID, start, int64(count), temporality),
+		newGauge(sumID, sum),
+	}
+}
+
+func (b *otlpBatch) counterFromTotal(
+	id string,
+	start uint64,
+	value int64,
+	temporality metricspb.AggregationTemporality,
+) *metrics.Metrics {
+	if temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		return newCounter(id, value)
+	}
+
+	return newCounter(id, b.delta(id, start, value))
+}
+
+// delta returns the increase of a cumulative series since the previous export.
+// A changed start time or a decreasing value means the producer was restarted.
+func (b *otlpBatch) delta(id string, start uint64, value int64) int64 {
+	prev, ok := b.last(id)
+	b.set(id, cumulativePoint{start: start, value: float64(value)})
+
+	if !ok || prev.start != start || float64(value) < prev.value {
+		return value
+	}
+
+	return value - int64(prev.value)
+}
+
+func numberValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+
+	return dp.GetAsDouble()
+}
+
+func newGauge(id string, value float64) *metrics.Metrics {
+	gaugeValue := metrics.Gauge(value)
+	return &metrics.Metrics{
+		ID:    id,
+		MType: metrics.GaugeMetricName,
+		Value: &gaugeValue,
+	}
+}
+
+func newCounter(id string, value int64) *metrics.Metrics {
+	counterValue := metrics.Counter(value)
+	return &metrics.Metrics{
+		ID:    id,
+		MType: metrics.CounterMetricName,
+		Delta: &counterValue,
+	}
+}
+
+func dataPointCount(m *metricspb.Metric) int {
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		return len(data.Gauge.GetDataPoints())
+	case *metricspb.Metric_Sum:
+		return len(data.Sum.GetDataPoints())
+	case *metricspb.Metric_Histogram:
+		return len(data.Histogram.GetDataPoints())
+	case *metricspb.Metric_ExponentialHistogram:
+		return len(data.ExponentialHistogram.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		return len(data.Summary.GetDataPoints())
+	default:
+		return 0
+	}
+}
+
+func seriesID(name string, resourceLabels map[string]string, attributes []*commonpb.KeyValue) string {
+	return formatSeriesID(name, mergeLabels(resourceLabels, attributesToLabels(attributes)))
+}
+
+// formatSeriesID writes the labels sorted by key with the values quoted the way
+// Prometheus does, so that distinct series never share an ID. '/' is escaped as \x2f
+// everywhere, an ID has to fit the name segment of /value/{type}/{name}.
+func formatSeriesID(name string, labels map[string]string) string {
+	name = escapeSeriesName(name)
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, escapeSeriesName(k)+"="+quoteLabelValue(labels[k]))
+	}
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeSeriesName escapes the characters of metric and label names that delimit the
+// labels of a series ID.
+func escapeSeriesName(s string) string {
+	if !strings.ContainsAny(s, "\\/{}=,\"\n") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			b.WriteString(`\\`)
+		case '/', '{', '}', '=', ',', '"', '\n':
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+func quoteLabelValue(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '/':
+			b.WriteString(`\x2f`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+func mergeLabels(base, override map[string]string) map[string]string {
+	labels := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		labels[k] = v
+	}
+	for k, v := range override {
+		labels[k] = v
+	}
+
+	return labels
+}
+
+// attributesToLabels keeps attributes with scalar values, arrays, maps and bytes have no label form.
+func attributesToLabels(attributes []*commonpb.KeyValue) map[string]string {
+	labels := make(map[string]string, len(attributes))
+	for _, kv := range attributes {
+		switch v := kv.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			labels[kv.GetKey()] = v.StringValue
+		case *commonpb.AnyValue_BoolValue:
+			labels[kv.GetKey()] = strconv.FormatBool(v.BoolValue)
+		case *commonpb.AnyValue_IntValue:
+			labels[kv.GetKey()] = strconv.FormatInt(v.IntValue, 10)
+		case *commonpb.AnyValue_DoubleValue:
+			labels[kv.GetKey()] = strconv.FormatFloat(v.DoubleValue, 'g', -1, 64)
+		}
+	}
+
+	return labels
+}
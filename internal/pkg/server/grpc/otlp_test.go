@@ -0,0 +1,215 @@
+package grpc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/server"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/server/grpc"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+func exportRequest(m ...*metricspb.Metric) *collectormetrics.ExportMetricsServiceRequest {
+	return &collectormetrics.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{
+				Attributes: []*commonpb.KeyValue{stringAttribute("service.name", "api")},
+			},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: m}},
+		}},
+	}
+}
+
+func cumulativeSum(name string, value int64) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name: name,
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+			DataPoints: []*metricspb.NumberDataPoint{{
+				StartTimeUnixNano: 1,
+				Value:             &metricspb.NumberDataPoint_AsInt{AsInt: value},
+			}},
+		}},
+	}
+}
+
+func TestOTLPServer_Export(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMetrics()
+	srv := grpc.NewOTLPServer(store)
+
+	gauge := &metricspb.Metric{
+		Name: "queue.size",
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{
+				Attributes: []*commonpb.KeyValue{stringAttribute("queue", "jobs")},
+				Value:      &metricspb.NumberDataPoint_AsDouble{AsDouble: 12.5},
+			}},
+		}},
+	}
+	histogram := &metricspb.Metric{
+		Name: "latency",
+		Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+			DataPoints: []*metricspb.HistogramDataPoint{{
+				Count:          3,
+				Sum:            func() *float64 { v := 0.6; return &v }(),
+				BucketCounts:   []uint64{1, 2},
+				ExplicitBounds: []float64{0.1},
+			}},
+		}},
+	}
+	summary := &metricspb.Metric{
+		Name: "legacy",
+		Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+			DataPoints: []*metricspb.SummaryDataPoint{{Count: 1}},
+		}},
+	}
+
+	resp, err := srv.Export(ctx, exportRequest(gauge, cumulativeSum("requests", 10), histogram, summary))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.GetPartialSuccess().GetRejectedDataPoints())
+
+	resp, err = srv.Export(ctx, exportRequest(cumulativeSum("requests", 15)))
+	require.NoError(t, err)
+	assert.Nil(t, resp.GetPartialSuccess())
+
+	tests := []struct {
+		id    string
+		mType string
+		want  float64
+	}{
+		{id: `queue.size{queue="jobs",service.name="api"}`, mType: metrics.GaugeMetricName, want: 12.5},
+		{id: `requests{service.name="api"}`, mType: metrics.CounterMetricName, want: 15},
+		{id: `latency_count{service.name="api"}`, mType: metrics.CounterMetricName, want: 3},
+		{id: `latency_sum{service.name="api"}`, mType: metrics.GaugeMetricName, want: 0.6},
+		{id: `latency_bucket{le="0.1",service.name="api"}`, mType: metrics.CounterMetricName, want: 1},
+		{id: `latency_bucket{le="+Inf",service.name="api"}`, mType: metrics.CounterMetricName, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			m, ok := store.GetMetric(ctx, tt.id, tt.mType)
+			require.True(t, ok)
+			require.Equal(t, tt.mType, m.MType)
+			if tt.mType == metrics.CounterMetricName {
+				assert.Equal(t, metrics.Counter(tt.want), *m.Delta)
+			} else {
+				assert.Equal(t, metrics.Gauge(tt.want), *m.Value)
+			}
+		})
+	}
+}
+
+func TestOTLPServer_ExportEscapesLabels(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMetrics()
+	srv := grpc.NewOTLPServer(store)
+
+	point := func(value float64, attributes ...*commonpb.KeyValue) *metricspb.NumberDataPoint {
+		return &metricspb.NumberDataPoint{
+			Attributes: attributes,
+			Value:      &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+		}
+	}
+	gauge := &metricspb.Metric{
+		Name: "http/requests",
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+			point(1, stringAttribute("a", "1,b=2")),
+			point(2, stringAttribute("a", "1"), stringAttribute("b", "2")),
+			point(3, stringAttribute("a", `1",b="2`)),
+			point(4, stringAttribute("path", "/api/v1")),
+		}}},
+	}
+	_, err := srv.Export(ctx, exportRequest(gauge))
+	require.NoError(t, err)
+
+	all, err := store.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 4, "every series gets its own ID")
+
+	// IDs stay addressable as the name of /value/{type}/{name}.
+	mux := chi.NewRouter()
+	server.RegisterHandlers(mux, store)
+	for id, m := range all {
+		assert.NotContains(t, id, "/")
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/value/gauge/"+url.PathEscape(id), nil))
+		assert.Equal(t, http.StatusOK, rec.Code, id)
+		assert.Equal(t, m.String(), rec.Body.String(), id)
+	}
+	assert.Contains(t, all, `http\x2frequests{a="1,b=2",service.name="api"}`)
+	assert.Contains(t, all, `http\x2frequests{path="\x2fapi\x2fv1",service.name="api"}`)
+}
+
+func TestOTLPServer_ExportTypeConflict(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMetrics()
+	require.NoError(t, store.UpdateGaugeMetric(ctx, `requests{service.name="api"}`, 1))
+	srv := grpc.NewOTLPServer(store)
+
+	gauge := &metricspb.Metric{
+		Name: "queue.size",
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 2}}},
+		}},
+	}
+	resp, err := srv.Export(ctx, exportRequest(gauge, cumulativeSum("requests", 10)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.GetPartialSuccess().GetRejectedDataPoints())
+
+	m, ok := store.GetMetric(ctx, `queue.size{service.name="api"}`, metrics.GaugeMetricName)
+	require.True(t, ok)
+	assert.Equal(t, metrics.Gauge(2), *m.Value)
+}
+
+// failingStore refuses updates while err is set.
+type failingStore struct {
+	storage.Store
+	err error
+}
+
+func (s *failingStore) UpdateMetrics(ctx context.Context, batch []*metrics.Metrics) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Store.UpdateMetrics(ctx, batch)
+}
+
+func TestOTLPServer_ExportKeepsStateOfFailedUpdates(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStore{Store: storage.NewMetrics(), err: errors.New("database is down")}
+	srv := grpc.NewOTLPServer(store)
+
+	_, err := srv.Export(ctx, exportRequest(cumulativeSum("requests", 10)))
+	require.Error(t, err)
+
+	// The retried export still counts from zero.
+	store.err = nil
+	_, err = srv.Export(ctx, exportRequest(cumulativeSum("requests", 10)))
+	require.NoError(t, err)
+
+	m, ok := store.GetMetric(ctx, `requests{service.name="api"}`, metrics.CounterMetricName)
+	require.True(t, ok)
+	assert.Equal(t, metrics.Counter(10), *m.Delta)
+}
@@ -2,26 +2,47 @@ package grpc
 
 import (
 	"context"
+	"crypto/tls"
 	pb "github.com/mayr0y/animated-octo-couscous.git/api/server"
+	pbv2 "github.com/mayr0y/animated-octo-couscous.git/api/serverv2"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/ratelimit"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/watch"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 	"net"
+	"time"
 )
 
+// keepaliveMinTime is the shortest keepalive ping interval accepted from clients,
+// agents ping idle connections every 30 seconds.
+const keepaliveMinTime = 15 * time.Second
+
 type Server struct {
-	Address      string
-	metricsStore storage.Store
+	Address       string
+	Hub           *watch.Hub
+	SignKey       []byte
+	Replay        *sign.ReplayGuard
+	TrustedSubnet subnet.Set
+	Resolver      subnet.Resolver
+	TLSConfig     *tls.Config
+	Auth          *auth.Authenticator
+	Limiter       *ratelimit.Limiter
+	metricsStore  storage.Store
 	pb.UnimplementedMetricsServer
 }
 
 func (s *Server) Start(ctx context.Context, storage storage.Store) error {
-	s.metricsStore = storage
 	listen, err := net.Listen("tcp", s.Address)
 	if err != nil {
 		return err
 	}
-	grpcServer := grpc.NewServer()
-	pb.RegisterMetricsServer(grpcServer, s)
+	grpcServer := s.newGRPCServer(storage)
 	go func() {
 		<-ctx.Done()
 		grpcServer.GracefulStop()
@@ -29,3 +50,37 @@ func (s *Server) Start(ctx context.Context, storage storage.Store) error {
 
 	return grpcServer.Serve(listen)
 }
+
+func (s *Server) newGRPCServer(storage storage.Store) *grpc.Server {
+	s.metricsStore = storage
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             keepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
+		grpc.ChainUnaryInterceptor(
+			LoggingUnaryInterceptor,
+			SubnetUnaryInterceptor(s.TrustedSubnet, s.Resolver),
+			AuthUnaryInterceptor(s.Auth),
+			RateLimitUnaryInterceptor(s.Limiter, s.Resolver),
+			SignUnaryInterceptor(s.SignKey, s.Replay),
+		),
+		grpc.ChainStreamInterceptor(
+			LoggingStreamInterceptor,
+			SubnetStreamInterceptor(s.TrustedSubnet, s.Resolver),
+			AuthStreamInterceptor(s.Auth),
+			RateLimitStreamInterceptor(s.Limiter, s.Resolver),
+			SignStreamInterceptor(s.SignKey, s.Replay),
+		),
+	}
+	if s.TLSConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.TLSConfig)))
+	}
+
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(grpcServer, s)
+	pbv2.RegisterMetricsServer(grpcServer, NewServerV2(storage, s.Hub))
+	collectormetrics.RegisterMetricsServiceServer(grpcServer, NewOTLPServer(storage))
+
+	return grpcServer
+}
@@ -0,0 +1,222 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	pbv2 "github.com/mayr0y/animated-octo-couscous.git/api/serverv2"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/ratelimit"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/watch"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ServerV2 implements the server.v2 API: float64 values, status code errors
+// and per-item results for batch updates.
+type ServerV2 struct {
+	metricsStore storage.Store
+	hub          *watch.Hub
+	pbv2.UnimplementedMetricsServer
+}
+
+func NewServerV2(s storage.Store, hub *watch.Hub) *ServerV2 {
+	return &ServerV2{
+		metricsStore: s,
+		hub:          hub,
+	}
+}
+
+func (s *ServerV2) UpdateMetrics(stream pbv2.Metrics_UpdateMetricsServer) error {
+	batch := make([]*pbv2.Metric, 0)
+	for {
+		message, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, message.GetMetric())
+	}
+
+	return stream.SendAndClose(&pbv2.UpdateMetricsResponse{
+		Results: s.applyBatch(stream.Context(), batch),
+	})
+}
+
+func (s *ServerV2) UpdateMetricsBatch(
+	ctx context.Context,
+	req *pbv2.UpdateMetricsBatchRequest,
+) (*pbv2.UpdateMetricsResponse, error) {
+	return &pbv2.UpdateMetricsResponse{
+		Results: s.applyBatch(ctx, req.GetMetrics()),
+	}, nil
+}
+
+func (s *ServerV2) UpdateMetric(ctx context.Context, req *pbv2.UpdateMetricRequest) (*pbv2.Metric, error) {
+	metric, err := fromProtoV2(req.GetMetric())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err = s.metricsStore.UpdateMetrics(ctx, []*metrics.Metrics{metric}); err != nil {
+		return nil, storeError(err)
+	}
+
+	updated, ok := s.metricsStore.GetMetric(ctx, metric.ID, metric.MType)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "metric %s is not found after update", metric.ID)
+	}
+
+	return toProtoV2(updated), nil
+}
+
+func (s *ServerV2) GetMetric(ctx context.Context, req *pbv2.GetMetricRequest) (*pbv2.Metric, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "metric id is required")
+	}
+	if !validMetricType(req.GetType()) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown metric type: %s", req.GetType())
+	}
+
+	metric, ok := s.metricsStore.GetMetric(ctx, req.GetId(), req.GetType())
+	if !ok || metric.MType != req.GetType() {
+		return nil, status.Errorf(codes.NotFound, "metric %s:%s is not found", req.GetType(), req.GetId())
+	}
+
+	return toProtoV2(metric), nil
+}
+
+func (s *ServerV2) ListMetrics(ctx context.Context, req *pbv2.ListMetricsRequest) (*pbv2.ListMetricsResponse, error) {
+	page, nextPageToken, err := listMetrics(ctx, s.metricsStore, req.GetPageSize(), req.GetPageToken(), req.GetPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pbv2.ListMetricsResponse{
+		Metrics:       make([]*pbv2.Metric, 0, len(page)),
+		NextPageToken: nextPageToken,
+	}
+	for _, m := range page {
+		resp.Metrics = append(resp.Metrics, toProtoV2(m))
+	}
+
+	return resp, nil
+}
+
+func (s *ServerV2) Watch(req *pbv2.WatchRequest, stream pbv2.Metrics_WatchServer) error {
+	return watchMetrics(stream.Context(), s.hub, req.GetTypes(), req.GetNamePattern(), func(m *metrics.Metrics) error {
+		return stream.Send(toProtoV2(m))
+	})
+}
+
+// applyBatch stores all valid items of the batch and reports a status per item.
+// Stores apply a batch atomically, so when the batch is refused it is retried
+// item by item to find out which items were at fault.
+func (s *ServerV2) applyBatch(ctx context.Context, batch []*pbv2.Metric) []*pbv2.MetricResult {
+	results := make([]*pbv2.MetricResult, len(batch))
+	valid := make([]*metrics.Metrics, 0, len(batch))
+	validIndexes := make([]int, 0, len(batch))
+
+	for i, m := range batch {
+		metric, err := fromProtoV2(m)
+		if err != nil {
+			results[i] = metricResult(m.GetId(), status.New(codes.InvalidArgument, err.Error()))
+			continue
+		}
+		valid = append(valid, metric)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if len(valid) == 0 {
+		return results
+	}
+
+	if err := s.metricsStore.UpdateMetrics(ctx, valid); err == nil {
+		for _, i := range validIndexes {
+			results[i] = metricResult(batch[i].GetId(), status.New(codes.OK, ""))
+		}
+		return results
+	}
+
+	for j, metric := range valid {
+		st := status.New(codes.OK, "")
+		if err := s.metricsStore.UpdateMetrics(ctx, []*metrics.Metrics{metric}); err != nil {
+			st = status.Convert(storeError(err))
+		}
+		results[validIndexes[j]] = metricResult(metric.ID, st)
+	}
+
+	return results
+}
+
+func metricResult(id string, st *status.Status) *pbv2.MetricResult {
+	return &pbv2.MetricResult{
+		Id:     id,
+		Status: st.Proto(),
+	}
+}
+
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrMetricTypeMismatch):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, auth.ErrForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ratelimit.ErrBatchTooLarge), errors.Is(err, ratelimit.ErrSeriesQuota):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Errorf(codes.Internal, "update metrics: %v", err)
+	}
+}
+
+func fromProtoV2(m *pbv2.Metric) (*metrics.Metrics, error) {
+	if m == nil {
+		return nil, errors.New("metric is required")
+	}
+	if m.GetId() == "" {
+		return nil, errors.New("metric id is required")
+	}
+
+	switch m.GetType() {
+	case metrics.GaugeMetricName:
+		gaugeValue := metrics.Gauge(m.GetValue())
+		return &metrics.Metrics{
+			ID:    m.GetId(),
+			MType: m.GetType(),
+			Value: &gaugeValue,
+		}, nil
+	case metrics.CounterMetricName:
+		counterValue := metrics.Counter(m.GetDelta())
+		return &metrics.Metrics{
+			ID:    m.GetId(),
+			MType: m.GetType(),
+			Delta: &counterValue,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown metric type: %s", m.GetType())
+	}
+}
+
+func toProtoV2(m *metrics.Metrics) *pbv2.Metric {
+	metric := &pbv2.Metric{
+		Id:   m.ID,
+		Type: m.MType,
+	}
+	if m.Value != nil {
+		metric.Value = float64(*m.Value)
+	}
+	if m.Delta != nil {
+		metric.Delta = int64(*m.Delta)
+	}
+
+	return metric
+}
@@ -0,0 +1,53 @@
+package grpc_test
+
+import (
+	"context"
+	"testing"
+
+	pbv2 "github.com/mayr0y/animated-octo-couscous.git/api/serverv2"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/server/grpc"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServerV2_UpdateMetricsBatch(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMetrics()
+	s := grpc.NewServerV2(store, nil)
+
+	require.NoError(t, store.UpdateCounterMetric(ctx, "Alloc", 1))
+
+	resp, err := s.UpdateMetricsBatch(ctx, &pbv2.UpdateMetricsBatchRequest{Metrics: []*pbv2.Metric{
+		{Id: "HeapAlloc", Type: metrics.GaugeMetricName, Value: 123456789.123456789},
+		{Id: "Alloc", Type: metrics.GaugeMetricName, Value: 1},
+		{Id: "Unknown", Type: "histogram"},
+		{Id: "PollCount", Type: metrics.CounterMetricName, Delta: 3},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.GetResults(), 4)
+
+	wantCodes := []codes.Code{codes.OK, codes.FailedPrecondition, codes.InvalidArgument, codes.OK}
+	for i, want := range wantCodes {
+		assert.Equal(t, int32(want), resp.GetResults()[i].GetStatus().GetCode(), resp.GetResults()[i].GetId())
+	}
+
+	got, err := s.GetMetric(ctx, &pbv2.GetMetricRequest{Id: "HeapAlloc", Type: metrics.GaugeMetricName})
+	require.NoError(t, err)
+	assert.Equal(t, 123456789.123456789, got.GetValue())
+
+	got, err = s.GetMetric(ctx, &pbv2.GetMetricRequest{Id: "PollCount", Type: metrics.CounterMetricName})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), got.GetDelta())
+
+	_, err = s.GetMetric(ctx, &pbv2.GetMetricRequest{Id: "Missing", Type: metrics.GaugeMetricName})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.UpdateMetric(ctx, &pbv2.UpdateMetricRequest{
+		Metric: &pbv2.Metric{Id: "Alloc", Type: metrics.GaugeMetricName, Value: 1},
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
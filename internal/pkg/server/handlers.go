@@ -3,14 +3,20 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/middleware"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/ratelimit"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
 	"github.com/sirupsen/logrus"
 )
@@ -62,6 +68,8 @@ func getMetricJSON(s storage.Store) func(w http.ResponseWriter, r *http.Request)
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			logrus.Errorf("Error: %s", err)
+			http.Error(w, err.Error(), middleware.BodyErrorStatus(err))
+			return
 		}
 
 		err = json.Unmarshal(body, &metric)
@@ -95,27 +103,24 @@ func getMetricJSON(s storage.Store) func(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// UpdatesBatchHandler applies a JSON array of metrics atomically: the whole batch is
+// validated first and then applied with one UpdateMetrics call, so that a refused
+// batch leaves nothing behind for the agent to count twice when it sends it again.
+// The request body limit bounds the batch.
 func UpdatesBatchHandler(s storage.Store) func(r chi.Router) {
 	return func(r chi.Router) {
 		r.Post("/", func(w http.ResponseWriter, r *http.Request) {
-			var metricBatch []*metrics.Metrics
-			body, err := io.ReadAll(r.Body)
-			if err != nil {
-				logrus.Errorf("Error: %s", err)
-			}
+			batch, err := decodeBatch(r.Body)
+			if err == nil && len(batch) > 0 {
+				requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+				defer requestCancel()
 
-			err = json.Unmarshal(body, &metricBatch)
-			if err != nil {
-				logrus.Infof("Cannot decode provided data: %s", err)
-				return
+				err = s.UpdateMetrics(requestContext, batch)
 			}
-
-			requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
-			defer requestCancel()
-
-			err = s.UpdateMetrics(requestContext, metricBatch)
 			if err != nil {
-				http.Error(w, "Failed to update metrics", http.StatusBadRequest)
+				logrus.Infof("Cannot update metrics batch: %s", err)
+				http.Error(w, fmt.Sprintf("Failed to update metrics: %s", err), batchErrorStatus(err))
+				return
 			}
 			w.WriteHeader(http.StatusOK)
 		})
@@ -131,6 +136,8 @@ func updateMetricJSON(s storage.Store) func(w http.ResponseWriter, r *http.Reque
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			logrus.Errorf("Error: %s", err)
+			http.Error(w, err.Error(), middleware.BodyErrorStatus(err))
+			return
 		}
 		err = json.Unmarshal(body, &metric)
 		if err != nil {
@@ -145,7 +152,8 @@ func updateMetricJSON(s storage.Store) func(w http.ResponseWriter, r *http.Reque
 			}
 			err = s.UpdateCounterMetric(requestContext, metric.ID, *metric.Delta)
 			if err != nil {
-				http.Error(w, metric.MType, http.StatusBadRequest)
+				http.Error(w, metric.MType, updateErrorStatus(err))
+				return
 			}
 			w.WriteHeader(http.StatusOK)
 		case metrics.GaugeMetricName:
@@ -154,7 +162,8 @@ func updateMetricJSON(s storage.Store) func(w http.ResponseWriter, r *http.Reque
 			}
 			err = s.UpdateGaugeMetric(requestContext, metric.ID, *metric.Value)
 			if err != nil {
-				http.Error(w, metric.MType, http.StatusBadRequest)
+				http.Error(w, metric.MType, updateErrorStatus(err))
+				return
 			}
 			w.WriteHeader(http.StatusOK)
 		default:
@@ -187,7 +196,7 @@ func getAllMetricsHandler(s storage.Store) func(r chi.Router) {
 func getMetric(s storage.Store) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		metricType := chi.URLParam(r, metricType)
-		metricName := chi.URLParam(r, metricName)
+		metricName := urlParam(r, metricName)
 
 		var metricData string
 
@@ -224,10 +233,24 @@ func getMetric(s storage.Store) func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// urlParam returns a path parameter unescaped. chi matches the escaped path when the
+// client escaped more than the default, e.g. ',' as %2C in an OTLP series ID.
+func urlParam(r *http.Request, key string) string {
+	value := chi.URLParam(r, key)
+	if r.URL.RawPath == "" {
+		return value
+	}
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+
+	return value
+}
+
 func updateMetricHandler(s storage.Store) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		metricType := chi.URLParam(r, metricType)
-		metricName := chi.URLParam(r, metricName)
+		metricName := urlParam(r, metricName)
 		metricValue := chi.URLParam(r, "metricValue")
 
 		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
@@ -243,11 +266,40 @@ func updateMetricHandler(s storage.Store) func(w http.ResponseWriter, r *http.Re
 			http.Error(w, metricType, http.StatusNotImplemented)
 		}
 		if err != nil {
-			http.Error(w, metricValue, http.StatusBadRequest)
+			http.Error(w, metricValue, updateErrorStatus(err))
 		}
 	}
 }
 
+// updateErrorStatus answers 4xx only for updates the server refuses for what they are.
+// Store failures are 5xx, agents keep such data and send it again instead of dropping
+// it as invalid.
+func updateErrorStatus(err error) int {
+	var numErr *strconv.NumError
+	switch {
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ratelimit.ErrBatchTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ratelimit.ErrSeriesQuota):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errInvalidBatch), errors.Is(err, storage.ErrMetricTypeMismatch), errors.As(err, &numErr):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func batchErrorStatus(err error) int {
+	if status := middleware.BodyErrorStatus(err); status != http.StatusBadRequest {
+		return status
+	}
+
+	return updateErrorStatus(err)
+}
+
 func updateGaugeMetric(ctx context.Context, metricName string, valueMetric string, s storage.Store) error {
 	val, err := strconv.ParseFloat(valueMetric, 64)
 	if err == nil {
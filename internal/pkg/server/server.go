@@ -2,7 +2,10 @@ package server
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/server/grpc"
+	"io"
 	"net/http"
 	"os/signal"
 	"sync"
@@ -11,12 +14,49 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/certs"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/middleware"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/ratelimit"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/server/config"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/watch"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	watchBufferSize = 256
+	shutdownTimeout = 5 * time.Second
+
+	certReloadInterval = time.Minute
+	replayCacheSize    = 100000
+)
+
+func newTokenStore(c *config.ServerConfig) (auth.TokenStore, error) {
+	switch {
+	case c.TokensDB:
+		if c.DatabaseDSN == "" {
+			return nil, errors.New("tokens in the database require a database DSN")
+		}
+		db, err := sql.Open("pgx", c.DatabaseDSN)
+		if err != nil {
+			return nil, err
+		}
+		store, err := auth.NewDBStore(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return store, nil
+	case c.TokensFile != "":
+		return auth.NewFileStore(c.TokensFile)
+	default:
+		return nil, nil
+	}
+}
+
 func StartListener(parent context.Context, c *config.ServerConfig) {
 	logrus.Info("Init store...")
 	logrus.Infof("ServerAddress: %v", c.ServerAddress)
@@ -53,6 +93,46 @@ func StartListener(parent context.Context, c *config.ServerConfig) {
 
 	logrus.Info("Init store successfully")
 
+	trustedSubnet, err := c.GetTrustedSubnet()
+	if err != nil {
+		logrus.Errorf("Error parse trusted subnet: %v", err)
+		return
+	}
+	trustedProxies, err := c.GetTrustedProxies()
+	if err != nil {
+		logrus.Errorf("Error parse trusted proxies: %v", err)
+		return
+	}
+	resolver := subnet.Resolver{Proxies: trustedProxies, TrustRealIP: c.TrustRealIP}
+
+	tokenStore, err := newTokenStore(c)
+	if err != nil {
+		logrus.Errorf("Error init token store: %v", err)
+		return
+	}
+	if closer, ok := tokenStore.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	var authenticator *auth.Authenticator
+	if tokenStore != nil || c.AdminToken != "" {
+		authenticator = auth.NewAuthenticator(tokenStore, c.AdminToken)
+	}
+
+	hub := watch.NewHub(watchBufferSize)
+	// Quotas count only the writes the token is allowed to make.
+	metricStore = ratelimit.NewStore(storage.NewPublishingStore(metricStore, hub),
+		c.MaxBatchSize, c.ClientMaxSeries, time.Duration(c.ClientSeriesTTL)*time.Second)
+	metricStore = auth.NewStore(metricStore)
+
+	var limiter *ratelimit.Limiter
+	if c.ClientRateLimit > 0 {
+		limiter = ratelimit.NewLimiter(c.ClientRateLimit, c.ClientRateBurst)
+	}
+
+	// HTTP and gRPC share the replay guard, a nonce is accepted once on either.
+	replay := sign.NewReplayGuard(time.Duration(c.ReplayWindow)*time.Second, replayCacheSize)
+
 	var (
 		mux = chi.NewRouter()
 		srv = &http.Server{
@@ -60,24 +140,61 @@ func StartListener(parent context.Context, c *config.ServerConfig) {
 			Handler: mux,
 		}
 		grpcSrv = grpc.Server{
-			Address: c.GRPCAddress,
+			Address:       c.GRPCAddress,
+			Hub:           hub,
+			SignKey:       c.SignKeyByte,
+			Replay:        replay,
+			TrustedSubnet: trustedSubnet,
+			Resolver:      resolver,
+			Auth:          authenticator,
+			Limiter:       limiter,
 		}
 	)
 
+	var reloader *certs.Reloader
+	if c.TLSCertFile != "" {
+		reloader, err = certs.NewReloader(c.TLSCertFile, c.TLSKeyFile, c.TLSClientCAFile)
+		if err != nil {
+			logrus.Errorf("Error load TLS certificates: %v", err)
+			return
+		}
+		srv.TLSConfig = reloader.ServerConfig()
+		grpcSrv.TLSConfig = reloader.ServerConfig()
+		go reloader.Run(ctx, certReloadInterval)
+	} else if c.TLSClientCAFile != "" {
+		logrus.Error("Client certificate authentication requires a server certificate")
+		return
+	}
+
 	mux.Use(
 		middleware.LoggingMiddleware,
-		middleware.CryptMiddleware(c.SignKeyByte),
+		middleware.CompressMiddleware,
+		middleware.BodyLimitMiddleware(c.MaxBodySize),
+		middleware.DecompressMiddleware(c.MaxBodySize),
+		middleware.TrustedSubnetMiddleware(trustedSubnet, resolver, isWrite),
+	)
+	if authenticator != nil {
+		mux.Use(middleware.AuthMiddleware(authenticator, RouteScope))
+	}
+	mux.Use(
+		middleware.RateLimitMiddleware(limiter, resolver, isWrite),
+		middleware.CryptMiddleware(c.SignKeyByte, replay, isWrite),
 	)
 
 	if c.PrivateKey != "" {
-		privateKey, err := c.GetPrivateKey()
+		keyring, err := c.GetKeyring()
 		if err != nil {
 			logrus.Errorf("Error get private key: %v", err)
+			return
 		}
-		mux.Use(middleware.DecryptMiddleware(privateKey))
+		mux.Use(middleware.DecryptMiddleware(keyring))
 	}
 
 	RegisterHandlers(mux, metricStore)
+	mux.Route("/api/v1/watch", WatchHandler(hub))
+	if tokenStore != nil {
+		mux.Route("/api/v1/tokens", TokensHandler(tokenStore))
+	}
 
 	if c.Restore {
 		if err = metricStore.LoadMetrics(c.FileStoragePath); err != nil {
@@ -103,10 +220,15 @@ func StartListener(parent context.Context, c *config.ServerConfig) {
 	go func() {
 		defer wg.Done()
 		logrus.Info("Server is running...")
-		if err = srv.ListenAndServe(); err != nil {
+		var err error
+		if reloader != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logrus.Fatalf("Error with server running: %v", err)
 		}
-
 	}()
 
 	wg.Add(1)
@@ -117,7 +239,13 @@ func StartListener(parent context.Context, c *config.ServerConfig) {
 		}
 	}()
 
-	if err = srv.Shutdown(ctx); err != nil {
+	<-ctx.Done()
+	hub.Close()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+
+	if err = srv.Shutdown(shutdownCtx); err != nil {
 		logrus.Errorf("server shutdown %v", err)
 		return
 	}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/sirupsen/logrus"
+)
+
+type issueTokenRequest struct {
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+type issueTokenResponse struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name"`
+	Token    string       `json:"token"`
+	Scopes   []auth.Scope `json:"scopes"`
+	Prefixes []string     `json:"prefixes,omitempty"`
+}
+
+// TokensHandler issues API tokens. The secret is returned once in the response and
+// only its hash is stored.
+func TokensHandler(store auth.TokenStore) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+			var req issueTokenRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Cannot decode provided data", http.StatusBadRequest)
+				return
+			}
+			if strings.TrimSpace(req.Name) == "" {
+				http.Error(w, "Name is required field", http.StatusBadRequest)
+				return
+			}
+
+			scopes := make([]auth.Scope, 0, len(req.Scopes))
+			for _, s := range req.Scopes {
+				scope, err := auth.ParseScope(s)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				scopes = append(scopes, scope)
+			}
+
+			secret, token, err := auth.Issue(r.Context(), store, req.Name, scopes, req.Prefixes)
+			if err != nil {
+				logrus.Errorf("Error issue token: %v", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logrus.Infof("Issued token %s for %s", token.ID, token.Name)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			if err = json.NewEncoder(w).Encode(issueTokenResponse{
+				ID:       token.ID,
+				Name:     token.Name,
+				Token:    secret,
+				Scopes:   token.Scopes,
+				Prefixes: token.Prefixes,
+			}); err != nil {
+				logrus.Errorf("Cannot send request: %q", err)
+			}
+		})
+	}
+}
+
+// RouteScope is the scope a token needs for a request; the health check is public.
+func RouteScope(r *http.Request) auth.Scope {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/ping"):
+		return ""
+	case strings.HasPrefix(r.URL.Path, "/api/v1/tokens"):
+		return auth.ScopeAdmin
+	case strings.HasPrefix(r.URL.Path, "/update/"), strings.HasPrefix(r.URL.Path, "/updates/"):
+		return auth.ScopeWrite
+	default:
+		return auth.ScopeRead
+	}
+}
+
+func isWrite(r *http.Request) bool {
+	return RouteScope(r) == auth.ScopeWrite
+}
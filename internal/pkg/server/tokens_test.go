@@ -0,0 +1,60 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/middleware"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/server"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokens(t *testing.T) {
+	tokens, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+
+	mux := chi.NewRouter()
+	mux.Use(middleware.AuthMiddleware(auth.NewAuthenticator(tokens, "admin-secret"), server.RouteScope))
+	server.RegisterHandlers(mux, auth.NewStore(storage.NewMetrics()))
+	mux.Route("/api/v1/tokens", server.TokensHandler(tokens))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	do := func(method, path, token, body string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	issue := `{"name": "agent-1", "scopes": ["write"], "prefixes": ["agent1."]}`
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/v1/tokens/", "", issue).StatusCode)
+
+	resp := do(http.MethodPost, "/api/v1/tokens/", "admin-secret", issue)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var issued struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&issued))
+	require.NotEmpty(t, issued.Token)
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/v1/tokens/", issued.Token, issue).StatusCode)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/update/gauge/agent1.Alloc/1", issued.Token, "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/update/gauge/Alloc/1", issued.Token, "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/value/gauge/agent1.Alloc", issued.Token, "").StatusCode)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/value/gauge/agent1.Alloc", "admin-secret", "").StatusCode)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/ping", "", "").StatusCode)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/auth"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/watch"
+	"github.com/sirupsen/logrus"
+)
+
+const watchHeartbeatInterval = 15 * time.Second
+
+// WatchHandler streams accepted metric updates as server-sent events.
+// Query parameters: type (repeatable or comma separated) and name (path.Match pattern).
+func WatchHandler(h *watch.Hub) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+				return
+			}
+
+			var types []string
+			for _, t := range r.URL.Query()["type"] {
+				types = append(types, strings.Split(t, ",")...)
+			}
+
+			sub, err := h.Subscribe(watch.Filter{
+				Types:       types,
+				NamePattern: r.URL.Query().Get("name"),
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer h.Unsubscribe(sub)
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			w.WriteHeader(http.StatusOK)
+			flusher.Flush()
+
+			heartbeat := time.NewTicker(watchHeartbeatInterval)
+			defer heartbeat.Stop()
+
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-heartbeat.C:
+					if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+						return
+					}
+					flusher.Flush()
+				case metric, ok := <-sub.Updates():
+					if !ok {
+						if errors.Is(sub.Err(), watch.ErrSlowConsumer) || errors.Is(sub.Err(), watch.ErrHubClosed) {
+							_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", sub.Err())
+							flusher.Flush()
+						}
+						return
+					}
+					if !auth.AllowsMetric(r.Context(), metric.ID) {
+						continue
+					}
+
+					data, err := json.Marshal(metric)
+					if err != nil {
+						logrus.Errorf("Cannot encode metric data: %q", err)
+						continue
+					}
+					if _, err = fmt.Fprintf(w, "event: metric\ndata: %s\n\n", data); err != nil {
+						return
+					}
+					flusher.Flush()
+				}
+			}
+		})
+	}
+}
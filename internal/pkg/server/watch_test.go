@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/server"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/storage"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/watch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchHandler(t *testing.T) {
+	hub := watch.NewHub(10)
+	store := storage.NewPublishingStore(storage.NewMetrics(), hub)
+
+	mux := chi.NewRouter()
+	server.RegisterHandlers(mux, store)
+	mux.Route("/api/v1/watch", server.WatchHandler(hub))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/v1/watch?type=counter&name=Poll*", nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	for _, url := range []string{"/update/gauge/PollGauge/1", "/update/counter/Other/1", "/update/counter/PollCount/5"} {
+		updateResp, err := http.Post(ts.URL+url, "text/plain", nil)
+		require.NoError(t, err)
+		_ = updateResp.Body.Close()
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+		}
+	}
+
+	assert.JSONEq(t, `{"id":"PollCount","type":"counter","delta":5}`, data)
+}
+
+func TestWatchHandler_InvalidFilter(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Route("/api/v1/watch", server.WatchHandler(watch.NewHub(10)))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/watch?type=histogram")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,64 @@
+package sign_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayGuard(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	guard := sign.NewReplayGuard(time.Minute, 2)
+	ts := sign.Timestamp(now)
+
+	assert.NoError(t, guard.Check(ts, "a", now))
+	assert.ErrorIs(t, guard.Check(ts, "a", now), sign.ErrReplayedRequest)
+	assert.ErrorIs(t, guard.Check(sign.Timestamp(now.Add(-2*time.Minute)), "b", now), sign.ErrStaleRequest)
+	assert.ErrorIs(t, guard.Check(sign.Timestamp(now.Add(2*time.Minute)), "b", now), sign.ErrStaleRequest)
+	assert.ErrorIs(t, guard.Check("yesterday", "b", now), sign.ErrInvalidTimestamp)
+	assert.ErrorIs(t, guard.Check(ts, "", now), sign.ErrMissingReplayHeaders)
+
+	// Filling the cache evicts "a"; its timestamp must not be accepted again.
+	next := sign.Timestamp(now.Add(time.Second))
+	assert.NoError(t, guard.Check(next, "b", now))
+	assert.NoError(t, guard.Check(next, "c", now))
+	assert.ErrorIs(t, guard.Check(ts, "a", now), sign.ErrStaleRequest)
+	assert.ErrorIs(t, guard.Check(next, "c", now), sign.ErrReplayedRequest)
+
+	// Once the window has passed, old nonces are forgotten and new requests are accepted.
+	later := now.Add(5 * time.Minute)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, guard.Check(sign.Timestamp(later), strconv.Itoa(i), later))
+	}
+}
+
+func TestReplayGuardWithoutWindow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for _, window := range []time.Duration{0, -time.Second} {
+		guard := sign.NewReplayGuard(window, 2)
+		old := sign.Timestamp(now.Add(-time.Hour))
+
+		// Any age is accepted, but each nonce only once.
+		assert.NoError(t, guard.Check(old, "a", now))
+		assert.NoError(t, guard.Check(sign.Timestamp(now), "b", now))
+		assert.ErrorIs(t, guard.Check(old, "a", now.Add(time.Hour)), sign.ErrReplayedRequest)
+
+		// Evicting "a" still keeps its timestamp out.
+		assert.NoError(t, guard.Check(sign.Timestamp(now), "c", now))
+		assert.ErrorIs(t, guard.Check(old, "a", now), sign.ErrStaleRequest)
+	}
+}
+
+func TestSumRequest(t *testing.T) {
+	key := []byte("secret")
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	signature := sign.SumRequest(key, "1700000000", "nonce", body)
+
+	assert.True(t, sign.VerifyRequest(key, signature, "1700000000", "nonce", body))
+	assert.False(t, sign.VerifyRequest(key, signature, "1700000001", "nonce", body))
+	assert.False(t, sign.VerifyRequest(key, signature, "1700000000", "other", body))
+	assert.False(t, sign.VerifyRequest(key, sign.Sum(key, body), "", "", body))
+}
@@ -0,0 +1,28 @@
+package sign_test
+
+import (
+	"testing"
+
+	pbv2 "github.com/mayr0y/animated-octo-couscous.git/api/serverv2"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/sign"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSumMessagesFramesMessages(t *testing.T) {
+	key := []byte("secret")
+	alloc := &pbv2.Metric{Id: "Alloc", Type: "gauge", Value: 1}
+	poll := &pbv2.Metric{Id: "PollCount", Type: "counter", Delta: 1}
+
+	// Both sequences encode to the same bytes when concatenated.
+	together, err := sign.SumMessages(key, "1", "nonce",
+		&pbv2.UpdateMetricsBatchRequest{Metrics: []*pbv2.Metric{alloc, poll}},
+		&pbv2.UpdateMetricsBatchRequest{})
+	require.NoError(t, err)
+	split, err := sign.SumMessages(key, "1", "nonce",
+		&pbv2.UpdateMetricsBatchRequest{Metrics: []*pbv2.Metric{alloc}},
+		&pbv2.UpdateMetricsBatchRequest{Metrics: []*pbv2.Metric{poll}})
+	require.NoError(t, err)
+
+	assert.NotEqual(t, together, split)
+}
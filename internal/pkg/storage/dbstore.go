@@ -204,7 +204,10 @@ func (db *DBStore) GetMetric(ctx context.Context, name string, metricType string
 			`SELECT metric_delta FROM counter WHERE metric_id = $1`, name)
 
 		err := row.Scan(&counter)
-		if !errors.Is(err, nil) && !errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false
+		}
+		if err != nil {
 			logrus.Errorf("Error with get counter: %v", err)
 			return nil, false
 		}
@@ -216,7 +219,10 @@ func (db *DBStore) GetMetric(ctx context.Context, name string, metricType string
 			`SELECT metric_value FROM gauge WHERE metric_id = $1`, name)
 
 		err := row.Scan(&gauge)
-		if !errors.Is(err, nil) && !errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false
+		}
+		if err != nil {
 			logrus.Errorf("Error with get gauge: %v", err)
 			return nil, false
 		}
@@ -247,7 +253,7 @@ func (db *DBStore) GetMetrics(ctx context.Context) (map[string]*metrics.Metrics,
 	for counters.Next() {
 		var counter metrics.Counter
 		metric := metrics.Metrics{
-			MType: metrics.GaugeMetricName,
+			MType: metrics.CounterMetricName,
 			Delta: &counter,
 		}
 		err = counters.Scan(&metric.ID, metric.Delta)
@@ -17,7 +17,7 @@ type MemoryStore struct {
 	FileStoragePath string
 	storeInterval   time.Duration
 	tickerDone      chan struct{}
-	lock            sync.Mutex
+	lock            sync.RWMutex
 	db              *sql.DB
 }
 
@@ -41,25 +41,56 @@ func (m *MemoryStore) UpdateMetrics(_ context.Context, metricBatch []*metrics.Me
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	if err := m.validateBatch(metricBatch); err != nil {
+		return err
+	}
+
 	for _, metric := range metricBatch {
 		currentMetric, ok := m.Metrics[metric.ID]
 		switch {
 		case ok && metric.MType == metrics.GaugeMetricName && currentMetric.Value != nil:
-			currentMetric.Value = metric.Value
+			value := *metric.Value
+			currentMetric.Value = &value
 		case ok && metric.MType == metrics.GaugeMetricName && currentMetric.Value == nil:
-			return fmt.Errorf("mismatch metric type %s:%s", metric.ID, currentMetric.MType)
+			return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metric.ID, currentMetric.MType)
 		case ok && metric.MType == metrics.CounterMetricName && currentMetric.Delta != nil:
 			*(currentMetric.Delta) += *(metric.Delta)
 		case ok && metric.MType == metrics.CounterMetricName && currentMetric.Delta == nil:
-			return fmt.Errorf("mismatch metric type %s:%s", metric.ID, currentMetric.MType)
+			return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metric.ID, currentMetric.MType)
 		default:
-			m.Metrics[metric.ID] = metric
+			m.Metrics[metric.ID] = metric.Copy()
 		}
 	}
 
 	return nil
 }
 
+// validateBatch rejects the whole batch up front so that UpdateMetrics never applies it partially.
+func (m *MemoryStore) validateBatch(metricBatch []*metrics.Metrics) error {
+	batchTypes := make(map[string]string, len(metricBatch))
+	for _, metric := range metricBatch {
+		switch {
+		case metric.MType == metrics.GaugeMetricName && metric.Value == nil:
+			return fmt.Errorf("value is required for gauge %s", metric.ID)
+		case metric.MType == metrics.CounterMetricName && metric.Delta == nil:
+			return fmt.Errorf("delta is required for counter %s", metric.ID)
+		}
+
+		mType, ok := batchTypes[metric.ID]
+		if !ok {
+			if currentMetric, exists := m.Metrics[metric.ID]; exists {
+				mType, ok = currentMetric.MType, true
+			}
+		}
+		if ok && mType != metric.MType {
+			return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metric.ID, mType)
+		}
+		batchTypes[metric.ID] = metric.MType
+	}
+
+	return nil
+}
+
 func (m *MemoryStore) UpdateGaugeMetric(_ context.Context, metricName string, metricValue metrics.Gauge) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -69,7 +100,7 @@ func (m *MemoryStore) UpdateGaugeMetric(_ context.Context, metricName string, me
 	case ok && currentMetric.Value != nil:
 		*(currentMetric.Value) = metricValue
 	case ok && currentMetric.Value == nil:
-		return fmt.Errorf("mismatch metric type %s:%s", metricName, currentMetric.MType)
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricName, currentMetric.MType)
 	default:
 		m.Metrics[metricName] = &metrics.Metrics{
 			ID:    metricName,
@@ -89,7 +120,7 @@ func (m *MemoryStore) UpdateCounterMetric(_ context.Context, metricName string,
 	case ok && currentMetric.Delta != nil:
 		*(currentMetric.Delta) += metricValue
 	case ok && currentMetric.Delta == nil:
-		return fmt.Errorf("mismatch metric type %s:%s", metricName, currentMetric.MType)
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricName, currentMetric.MType)
 	default:
 		m.Metrics[metricName] = &metrics.Metrics{
 			ID:    metricName,
@@ -101,14 +132,30 @@ func (m *MemoryStore) UpdateCounterMetric(_ context.Context, metricName string,
 	return nil
 }
 
+// GetMetric returns a copy, the stored metric keeps changing under the lock.
 func (m *MemoryStore) GetMetric(_ context.Context, metricName string, _ string) (*metrics.Metrics, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
 	metric, ok := m.Metrics[metricName]
+	if !ok {
+		return nil, false
+	}
 
-	return metric, ok
+	return metric.Copy(), true
 }
 
+// GetMetrics returns copies of all the metrics.
 func (m *MemoryStore) GetMetrics(_ context.Context) (map[string]*metrics.Metrics, error) {
-	return m.Metrics, nil
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	all := make(map[string]*metrics.Metrics, len(m.Metrics))
+	for name, metric := range m.Metrics {
+		all[name] = metric.Copy()
+	}
+
+	return all, nil
 }
 
 func (m *MemoryStore) ResetCounterMetric(_ context.Context, metricName string) error {
@@ -121,7 +168,7 @@ func (m *MemoryStore) ResetCounterMetric(_ context.Context, metricName string) e
 	case ok && currentMetric.Delta != nil:
 		*(currentMetric.Delta) = zero
 	case ok && currentMetric.Delta == nil:
-		return fmt.Errorf("mismatch metric type %s:%s", metricName, currentMetric.MType)
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricName, currentMetric.MType)
 	default:
 		m.Metrics[metricName] = &metrics.Metrics{
 			ID:    metricName,
@@ -145,6 +192,9 @@ func (m *MemoryStore) LoadMetrics(filePath string) error {
 	}
 	defer file.Close()
 
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	jsonDecoder := json.NewDecoder(file)
 	return jsonDecoder.Decode(&m.Metrics)
 }
@@ -159,6 +209,9 @@ func (m *MemoryStore) SaveMetrics(filePath string) error {
 	}
 	defer file.Close()
 
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
 	encoder := json.NewEncoder(file)
 	return encoder.Encode(&m.Metrics)
 }
@@ -388,3 +388,54 @@ func TestFileStore_SaveMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemoryStore_ConcurrentReads(t *testing.T) {
+	ctx := context.Background()
+	m := storage.NewMetrics()
+	assert.NoError(t, m.UpdateCounterMetric(ctx, "PollCount", 1))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			_ = m.UpdateCounterMetric(ctx, "PollCount", 1)
+			_ = m.UpdateGaugeMetric(ctx, fmt.Sprintf("Gauge%d", i%10), metrics.Gauge(i))
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		all, err := m.GetMetrics(ctx)
+		assert.NoError(t, err)
+		for _, metric := range all {
+			_ = metric.String()
+		}
+		if metric, ok := m.GetMetric(ctx, "PollCount", metrics.CounterMetricName); ok {
+			_ = *metric.Delta
+		}
+	}
+	<-done
+
+	// Returned metrics are copies.
+	metric, _ := m.GetMetric(ctx, "PollCount", metrics.CounterMetricName)
+	*metric.Delta = 0
+	again, _ := m.GetMetric(ctx, "PollCount", metrics.CounterMetricName)
+	assert.Equal(t, metrics.Counter(1001), *again.Delta)
+}
+
+func TestInMemoryStore_UpdateMetricsCopiesValues(t *testing.T) {
+	ctx := context.Background()
+	m := storage.NewMetrics()
+
+	for _, value := range []metrics.Gauge{1, 2} {
+		assert.NoError(t, m.UpdateMetrics(ctx, []*metrics.Metrics{
+			{ID: "Alloc", MType: metrics.GaugeMetricName, Value: &value},
+		}))
+
+		// The caller may reuse its metric after the update.
+		value = 0
+	}
+
+	metric, ok := m.GetMetric(ctx, "Alloc", metrics.GaugeMetricName)
+	assert.True(t, ok)
+	assert.Equal(t, metrics.Gauge(2), *metric.Value)
+}
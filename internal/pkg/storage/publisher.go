@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+)
+
+type Publisher interface {
+	Publish(metricsBatch ...*metrics.Metrics)
+}
+
+// PublishingStore passes every accepted update to the publisher after the underlying store applied it.
+type PublishingStore struct {
+	Store
+	publisher Publisher
+}
+
+func NewPublishingStore(s Store, p Publisher) *PublishingStore {
+	return &PublishingStore{
+		Store:     s,
+		publisher: p,
+	}
+}
+
+func (p *PublishingStore) UpdateCounterMetric(ctx context.Context, name string, value metrics.Counter) error {
+	if err := p.Store.UpdateCounterMetric(ctx, name, value); err != nil {
+		return err
+	}
+
+	p.publisher.Publish(&metrics.Metrics{
+		ID:    name,
+		MType: metrics.CounterMetricName,
+		Delta: &value,
+	})
+
+	return nil
+}
+
+func (p *PublishingStore) UpdateGaugeMetric(ctx context.Context, name string, value metrics.Gauge) error {
+	if err := p.Store.UpdateGaugeMetric(ctx, name, value); err != nil {
+		return err
+	}
+
+	p.publisher.Publish(&metrics.Metrics{
+		ID:    name,
+		MType: metrics.GaugeMetricName,
+		Value: &value,
+	})
+
+	return nil
+}
+
+func (p *PublishingStore) UpdateMetrics(ctx context.Context, metricBatch []*metrics.Metrics) error {
+	// Subscribers are told the update as sent, snapshot the batch in case the store
+	// keeps or changes its metrics.
+	accepted := make([]*metrics.Metrics, 0, len(metricBatch))
+	for _, m := range metricBatch {
+		accepted = append(accepted, m.Copy())
+	}
+
+	if err := p.Store.UpdateMetrics(ctx, metricBatch); err != nil {
+		return err
+	}
+
+	p.publisher.Publish(accepted...)
+
+	return nil
+}
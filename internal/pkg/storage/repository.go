@@ -2,10 +2,13 @@ package storage
 
 import (
 	"context"
+	"errors"
 
 	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
 )
 
+var ErrMetricTypeMismatch = errors.New("mismatch metric type")
+
 type Store interface {
 	UpdateCounterMetric(ctx context.Context, name string, value metrics.Counter) error
 	UpdateGaugeMetric(ctx context.Context, name string, value metrics.Gauge) error
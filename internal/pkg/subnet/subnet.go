@@ -0,0 +1,120 @@
+package subnet
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	RealIPHeader       = "X-Real-IP"
+	ForwardedForHeader = "X-Forwarded-For"
+)
+
+// Set is a list of networks; an empty set contains nothing.
+type Set []*net.IPNet
+
+// Parse reads comma separated CIDRs. A bare address is treated as a single host network.
+func Parse(s string) (Set, error) {
+	var set Set
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", part)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			set = append(set, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, n)
+	}
+
+	return set, nil
+}
+
+func (s Set) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range s {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s Set) String() string {
+	parts := make([]string, 0, len(s))
+	for _, n := range s {
+		parts = append(parts, n.String())
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Resolver determines the client address of a request. X-Forwarded-For is only
+// honored when the direct peer is one of the trusted proxies; the nearest address
+// that is not a trusted proxy is the client. The X-Real-IP header, set by agents to
+// their outbound address, is honored from trusted proxies too, and from any peer
+// only when TrustRealIP is set: anyone can send it.
+type Resolver struct {
+	Proxies     Set
+	TrustRealIP bool
+}
+
+func (r Resolver) ClientIP(peer net.IP, realIP string, forwardedFor []string) net.IP {
+	trusted := r.Proxies.Contains(peer)
+	if trusted {
+		var hops []string
+		for _, v := range forwardedFor {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return nil
+			}
+			if !r.Proxies.Contains(ip) {
+				return ip
+			}
+		}
+	}
+
+	if realIP != "" && (trusted || r.TrustRealIP) {
+		return net.ParseIP(strings.TrimSpace(realIP))
+	}
+
+	return peer
+}
+
+func (r Resolver) RequestIP(req *http.Request) net.IP {
+	return r.ClientIP(HostIP(req.RemoteAddr), req.Header.Get(RealIPHeader), req.Header.Values(ForwardedForHeader))
+}
+
+// HostIP parses the host part of a host:port address.
+func HostIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	return net.ParseIP(host)
+}
@@ -0,0 +1,86 @@
+package subnet_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/subnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	set, err := subnet.Parse("192.168.1.0/24, 10.0.0.1 ,fd00::/8")
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.0/24,10.0.0.1/32,fd00::/8", set.String())
+
+	assert.True(t, set.Contains(net.ParseIP("192.168.1.200")))
+	assert.True(t, set.Contains(net.ParseIP("10.0.0.1")))
+	assert.False(t, set.Contains(net.ParseIP("10.0.0.2")))
+	assert.True(t, set.Contains(net.ParseIP("fd00::1")))
+	assert.False(t, set.Contains(nil))
+
+	empty, err := subnet.Parse("")
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	_, err = subnet.Parse("192.168.1.0/33")
+	assert.Error(t, err)
+	_, err = subnet.Parse("localhost")
+	assert.Error(t, err)
+}
+
+func TestResolverRequestIP(t *testing.T) {
+	proxies, err := subnet.Parse("10.0.0.0/8")
+	require.NoError(t, err)
+	resolver := subnet.Resolver{Proxies: proxies}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		realIP       string
+		forwardedFor []string
+		want         string
+	}{
+		{name: "direct", remoteAddr: "192.168.1.5:4000", want: "192.168.1.5"},
+		{name: "real ip from untrusted peer is ignored", remoteAddr: "203.0.113.7:4000", realIP: "192.168.1.5", want: "203.0.113.7"},
+		{
+			name:         "forwarded by trusted proxy",
+			remoteAddr:   "10.0.0.2:4000",
+			forwardedFor: []string{"198.51.100.1, 192.168.1.5", "10.0.0.3"},
+			want:         "192.168.1.5",
+		},
+		{
+			name:         "forwarded header from untrusted peer is ignored",
+			remoteAddr:   "203.0.113.7:4000",
+			forwardedFor: []string{"192.168.1.5"},
+			want:         "203.0.113.7",
+		},
+		{name: "real ip set by trusted proxy", remoteAddr: "10.0.0.2:4000", realIP: "192.168.1.5", want: "192.168.1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/updates/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set(subnet.RealIPHeader, tt.realIP)
+			}
+			for _, v := range tt.forwardedFor {
+				r.Header.Add(subnet.ForwardedForHeader, v)
+			}
+
+			assert.Equal(t, tt.want, resolver.RequestIP(r).String())
+		})
+	}
+}
+
+func TestResolverTrustRealIP(t *testing.T) {
+	resolver := subnet.Resolver{TrustRealIP: true}
+
+	r := httptest.NewRequest("POST", "/updates/", nil)
+	r.RemoteAddr = "203.0.113.7:4000"
+	r.Header.Set(subnet.RealIPHeader, "192.168.1.5")
+
+	assert.Equal(t, "192.168.1.5", resolver.RequestIP(r).String())
+}
@@ -0,0 +1,171 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+)
+
+const defaultBufferSize = 256
+
+var (
+	ErrSlowConsumer = errors.New("subscriber is too slow to keep up with updates")
+	ErrHubClosed    = errors.New("watch hub is closed")
+)
+
+// Filter selects updates by metric type and by a path.Match style name pattern.
+// Empty fields match everything.
+type Filter struct {
+	Types       []string
+	NamePattern string
+}
+
+func (f Filter) Validate() error {
+	for _, t := range f.Types {
+		if t != metrics.GaugeMetricName && t != metrics.CounterMetricName {
+			return fmt.Errorf("unknown metric type: %s", t)
+		}
+	}
+
+	if _, err := path.Match(f.NamePattern, ""); err != nil {
+		return fmt.Errorf("invalid name pattern %q: %w", f.NamePattern, err)
+	}
+
+	return nil
+}
+
+func (f Filter) Match(m *metrics.Metrics) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == m.MType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.NamePattern == "" {
+		return true
+	}
+	ok, _ := path.Match(f.NamePattern, m.ID)
+
+	return ok
+}
+
+type Subscription struct {
+	filter  Filter
+	updates chan *metrics.Metrics
+	err     error
+}
+
+// Updates is closed once the subscription ends, Err then reports why.
+func (s *Subscription) Updates() <-chan *metrics.Metrics {
+	return s.updates
+}
+
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Hub fans accepted metric updates out to subscribers. A subscriber whose
+// buffer is full is disconnected with ErrSlowConsumer instead of blocking writers.
+type Hub struct {
+	lock        sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	bufferSize  int
+	closed      bool
+}
+
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	return &Hub{
+		subscribers: make(map[*Subscription]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+func (h *Hub) Subscribe(f Filter) (*Subscription, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.closed {
+		return nil, ErrHubClosed
+	}
+
+	sub := &Subscription{
+		filter:  f,
+		updates: make(chan *metrics.Metrics, h.bufferSize),
+	}
+	h.subscribers[sub] = struct{}{}
+
+	return sub, nil
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.drop(sub, nil)
+}
+
+func (h *Hub) Publish(metricsBatch ...*metrics.Metrics) {
+	var slow []*Subscription
+
+	h.lock.RLock()
+subscribers:
+	for sub := range h.subscribers {
+		for _, m := range metricsBatch {
+			if !sub.filter.Match(m) {
+				continue
+			}
+
+			select {
+			case sub.updates <- m.Copy():
+			default:
+				slow = append(slow, sub)
+				continue subscribers
+			}
+		}
+	}
+	h.lock.RUnlock()
+
+	for _, sub := range slow {
+		h.drop(sub, ErrSlowConsumer)
+	}
+}
+
+func (h *Hub) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		sub.err = ErrHubClosed
+		close(sub.updates)
+		delete(h.subscribers, sub)
+	}
+}
+
+func (h *Hub) drop(sub *Subscription, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, ok := h.subscribers[sub]; !ok {
+		return
+	}
+
+	sub.err = err
+	close(sub.updates)
+	delete(h.subscribers, sub)
+}
@@ -0,0 +1,73 @@
+package watch_test
+
+import (
+	"testing"
+
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/metrics"
+	"github.com/mayr0y/animated-octo-couscous.git/internal/pkg/watch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(id string, value float64) *metrics.Metrics {
+	v := metrics.Gauge(value)
+	return &metrics.Metrics{ID: id, MType: metrics.GaugeMetricName, Value: &v}
+}
+
+func counter(id string, delta int64) *metrics.Metrics {
+	d := metrics.Counter(delta)
+	return &metrics.Metrics{ID: id, MType: metrics.CounterMetricName, Delta: &d}
+}
+
+func TestHub_PublishFiltered(t *testing.T) {
+	h := watch.NewHub(10)
+
+	sub, err := h.Subscribe(watch.Filter{Types: []string{metrics.GaugeMetricName}, NamePattern: "Heap*"})
+	require.NoError(t, err)
+
+	h.Publish(gauge("HeapAlloc", 1), gauge("Alloc", 2), counter("HeapCount", 3))
+	h.Unsubscribe(sub)
+
+	var got []string
+	for m := range sub.Updates() {
+		got = append(got, m.ID)
+	}
+	assert.Equal(t, []string{"HeapAlloc"}, got)
+	assert.NoError(t, sub.Err())
+}
+
+func TestHub_PublishCopiesValues(t *testing.T) {
+	h := watch.NewHub(10)
+	sub, err := h.Subscribe(watch.Filter{})
+	require.NoError(t, err)
+
+	m := gauge("Alloc", 1)
+	h.Publish(m)
+	*m.Value = 2
+
+	got := <-sub.Updates()
+	assert.Equal(t, metrics.Gauge(1), *got.Value)
+}
+
+func TestHub_SlowConsumer(t *testing.T) {
+	h := watch.NewHub(1)
+	slow, err := h.Subscribe(watch.Filter{})
+	require.NoError(t, err)
+
+	h.Publish(gauge("Alloc", 1), gauge("Alloc", 2))
+
+	<-slow.Updates()
+	_, ok := <-slow.Updates()
+	assert.False(t, ok)
+	assert.ErrorIs(t, slow.Err(), watch.ErrSlowConsumer)
+}
+
+func TestHub_InvalidFilter(t *testing.T) {
+	h := watch.NewHub(1)
+
+	_, err := h.Subscribe(watch.Filter{Types: []string{"histogram"}})
+	assert.Error(t, err)
+
+	_, err = h.Subscribe(watch.Filter{NamePattern: "["})
+	assert.Error(t, err)
+}